@@ -2,34 +2,282 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"strings"
 )
 
 var (
 	labelSeq = 0
-	funcname []rune
+	curFn    *fun
 	argreg1  = [6]string{"dil", "sil", "dl", "cl", "r8b", "r9b"}
+	argreg2  = [6]string{"di", "si", "dx", "cx", "r8w", "r9w"}
+	argreg4  = [6]string{"edi", "esi", "edx", "ecx", "r8d", "r9d"}
 	argreg8  = [6]string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
 )
 
+func argRegs(i int) [4]string {
+	return [4]string{argreg8[i], argreg4[i], argreg2[i], argreg1[i]}
+}
+
+func isMemoryClass(ty *typ) bool {
+	return ty.kind == tyStruct && sizeOf(ty) > 16
+}
+
+// hasFlonum reports whether every scalar of ty overlapping [lo, hi) is
+// floating-point.
+func hasFlonum(ty *typ, lo int, hi int, offset int) bool {
+	switch ty.kind {
+	case tyStruct:
+		for m := ty.members; m != nil; m = m.next {
+			if !hasFlonum(m.ty, lo, hi, offset+m.offset) {
+				return false
+			}
+		}
+		return true
+	case tyArray:
+		for i := 0; i < ty.arraySize; i++ {
+			if !hasFlonum(ty.base, lo, hi, offset+sizeOf(ty.base)*i) {
+				return false
+			}
+		}
+		return true
+	}
+	return offset < lo || hi <= offset || isFlonum(ty)
+}
+
+// classify returns, for each eightbyte of ty, whether it belongs to the
+// SSE class, along with the number of general and vector registers needed.
+func classify(ty *typ) ([]bool, int, int) {
+	sse := make([]bool, wordsOf(ty))
+	gp, fp := 0, 0
+	for i := range sse {
+		if ty.kind == tyStruct {
+			sse[i] = hasFlonum(ty, 8*i, 8*i+8, 0)
+		} else {
+			sse[i] = isFlonum(ty)
+		}
+		if sse[i] {
+			fp++
+		} else {
+			gp++
+		}
+	}
+	return sse, gp, fp
+}
+
+func flonumSuffix(ty *typ) string {
+	if ty.kind == tyFloat {
+		return "ss"
+	}
+	return "sd"
+}
+
+func wordsOf(ty *typ) int {
+	if ty.kind != tyStruct {
+		return 1
+	}
+	return (sizeOf(ty) + 7) / 8
+}
+
+func storeGp(r [4]string, off int, sz int) {
+	switch sz {
+	case 8:
+		fmt.Printf("  mov [rbp-%d], %s\n", off, r[0])
+	case 4:
+		fmt.Printf("  mov [rbp-%d], %s\n", off, r[1])
+	case 2:
+		fmt.Printf("  mov [rbp-%d], %s\n", off, r[2])
+	case 1:
+		fmt.Printf("  mov [rbp-%d], %s\n", off, r[3])
+	default:
+		for i := 0; i < sz; i++ {
+			fmt.Printf("  mov [rbp-%d], %s\n", off-i, r[3])
+			fmt.Printf("  shr %s, 8\n", r[0])
+		}
+	}
+}
+
+func storeXmm(i int, off int, sz int) {
+	if sz == 4 {
+		fmt.Printf("  movss [rbp-%d], xmm%d\n", off, i)
+	} else {
+		fmt.Printf("  movsd [rbp-%d], xmm%d\n", off, i)
+	}
+}
+
+func pushStruct(ty *typ) {
+	fmt.Printf("  pop rax\n")
+	for i := wordsOf(ty) - 1; i >= 0; i-- {
+		fmt.Printf("  push [rax+%d]\n", 8*i)
+	}
+}
+
+func pushArgs(args []*node, onStack []bool, stack bool) {
+	for i := len(args) - 1; i >= 0; i-- {
+		if onStack[i] != stack {
+			continue
+		}
+		gen(args[i])
+		if args[i].ty.kind == tyStruct {
+			pushStruct(args[i].ty)
+		}
+	}
+}
+
+func genFunCall(n *node) {
+	nargs := 0
+	for arg := n.args; arg != nil; arg = arg.next {
+		nargs++
+	}
+	args := make([]*node, nargs)
+	onStack := make([]bool, nargs)
+	gp := 0
+	fp := 0
+	if n.retBuf != nil && isMemoryClass(n.retBuf.ty) {
+		gp++
+	}
+	var regs []string
+	nstack := 0
+	i := 0
+	for arg := n.args; arg != nil; arg = arg.next {
+		args[i] = arg
+		sse, ngp, nfp := classify(arg.ty)
+		if isMemoryClass(arg.ty) || gp+ngp > len(argreg8) || fp+nfp > 8 {
+			onStack[i] = true
+			nstack += len(sse)
+		} else {
+			for _, f := range sse {
+				if f {
+					regs = append(regs, fmt.Sprintf("xmm%d", fp))
+					fp++
+				} else {
+					regs = append(regs, argreg8[gp])
+					gp++
+				}
+			}
+		}
+		i++
+	}
+	pushArgs(args, onStack, true)
+	pushArgs(args, onStack, false)
+	callee := string(n.funcname)
+	if n.lhs != nil {
+		gen(n.lhs)
+		fmt.Printf("  pop r10\n")
+		callee = "r10"
+	}
+	if n.retBuf != nil && isMemoryClass(n.retBuf.ty) {
+		fmt.Printf("  lea rdi, [rbp-%d]\n", n.retBuf.offset)
+	}
+	for _, r := range regs {
+		if r[0] == 'x' {
+			fmt.Printf("  pop rax\n")
+			fmt.Printf("  movq %s, rax\n", r)
+		} else {
+			fmt.Printf("  pop %s\n", r)
+		}
+	}
+	seq := labelSeq
+	labelSeq++
+	fmt.Printf("  mov rax, rsp\n")
+	fmt.Printf("  and rax, 15\n")
+	fmt.Printf("  jnz .Lcall%d\n", seq)
+	fmt.Printf("  mov rax, %d\n", fp)
+	fmt.Printf("  call %s\n", callee)
+	if nstack > 0 {
+		fmt.Printf("  add rsp, %d\n", 8*nstack)
+	}
+	fmt.Printf("  jmp .Lend%d\n", seq)
+	fmt.Printf(".Lcall%d:\n", seq)
+	fmt.Printf("  sub rsp, 8\n")
+	for i := 0; i < nstack; i++ {
+		fmt.Printf("  mov rax, [rsp+%d]\n", 8*(i+1))
+		fmt.Printf("  mov [rsp+%d], rax\n", 8*i)
+	}
+	fmt.Printf("  mov rax, %d\n", fp)
+	fmt.Printf("  call %s\n", callee)
+	fmt.Printf("  add rsp, %d\n", 8*nstack+8)
+	fmt.Printf(".Lend%d:\n", seq)
+	if n.retBuf != nil && !isMemoryClass(n.retBuf.ty) {
+		sz := sizeOf(n.retBuf.ty)
+		off := n.retBuf.offset
+		ret := [2][4]string{{"rax", "eax", "ax", "al"}, {"rdx", "edx", "dx", "dl"}}
+		sse, _, _ := classify(n.retBuf.ty)
+		gi, fi := 0, 0
+		for i, f := range sse {
+			w := sz - 8*i
+			if w > 8 {
+				w = 8
+			}
+			if f {
+				storeXmm(fi, off-8*i, w)
+				fi++
+			} else {
+				storeGp(ret[gi], off-8*i, w)
+				gi++
+			}
+		}
+		fmt.Printf("  lea rax, [rbp-%d]\n", off)
+	} else if isFlonum(n.ty) {
+		fmt.Printf("  movq rax, xmm0\n")
+	}
+	fmt.Printf("  push rax\n")
+}
+
 func genAddr(n *node) {
 	switch n.kind {
 	case ndVar:
-		if v := n.v; v.isLocal {
+		if v := n.v; v.ty.kind == tyVla {
+			fmt.Printf("  push [rbp-%d]\n", v.offset)
+		} else if v.isLocal {
 			fmt.Printf("  lea rax, [rbp-%d]\n", v.offset)
 			fmt.Printf("  push rax\n")
+		} else if v.isTls {
+			// Variables defined here use the local-exec model; those
+			// defined elsewhere find their offset from the thread
+			// pointer in the GOT (initial-exec).
+			fmt.Printf("  mov rax, fs:0\n")
+			if v.isExtern {
+				fmt.Printf("  add rax, [rip+%s@gottpoff]\n", string(v.name))
+			} else {
+				fmt.Printf("  add rax, offset %s@tpoff\n", string(v.name))
+			}
+			fmt.Printf("  push rax\n")
 		} else {
 			fmt.Printf("  push offset %s\n", string(v.name))
 		}
 		return
+	case ndVlaPtr:
+		fmt.Printf("  lea rax, [rbp-%d]\n", n.v.offset)
+		fmt.Printf("  push rax\n")
+		return
 	case ndDeref:
 		gen(n.lhs)
 		return
+	case ndMember:
+		genAddr(n.lhs)
+		fmt.Printf("  pop rax\n")
+		fmt.Printf("  add rax, %d\n", n.member.offset)
+		fmt.Printf("  push rax\n")
+		return
+	case ndFunCall:
+		if n.retBuf != nil {
+			gen(n)
+			return
+		}
+	case ndStmtExpr:
+		b := n.body
+		for ; b.next != nil; b = b.next {
+			gen(b)
+		}
+		genAddr(b)
+		return
 	}
 	errorTok(n.tok, "not an lvalue")
 }
 
 func genLval(n *node) {
-	if n.ty.kind == tyArray {
+	if n.ty.kind == tyArray || n.ty.kind == tyVla {
 		errorTok(n.tok, "not an lvalue")
 	}
 	genAddr(n)
@@ -37,8 +285,18 @@ func genLval(n *node) {
 
 func load(ty *typ) {
 	fmt.Printf("  pop rax\n")
-	if sizeOf(ty) == 1 {
+	if sizeOf(ty) == 1 && ty.isUnsigned {
+		fmt.Printf("  movzx rax, byte ptr [rax]\n")
+	} else if sizeOf(ty) == 1 {
 		fmt.Printf("  movsx rax, byte ptr [rax]\n")
+	} else if sizeOf(ty) == 2 && ty.isUnsigned {
+		fmt.Printf("  movzx rax, word ptr [rax]\n")
+	} else if sizeOf(ty) == 2 {
+		fmt.Printf("  movsx rax, word ptr [rax]\n")
+	} else if ty.kind == tyInt32 && !ty.isUnsigned {
+		fmt.Printf("  movsxd rax, dword ptr [rax]\n")
+	} else if sizeOf(ty) == 4 {
+		fmt.Printf("  mov eax, [rax]\n")
 	} else {
 		fmt.Printf("  mov rax, [rax]\n")
 	}
@@ -48,42 +306,629 @@ func load(ty *typ) {
 func store(ty *typ) {
 	fmt.Printf("  pop rdi\n")
 	fmt.Printf("  pop rax\n")
-	if sizeOf(ty) == 1 {
+	if ty.kind == tyStruct {
+		for i := 0; i < sizeOf(ty); i++ {
+			fmt.Printf("  mov r8b, [rdi+%d]\n", i)
+			fmt.Printf("  mov [rax+%d], r8b\n", i)
+		}
+	} else if sizeOf(ty) == 1 {
 		fmt.Printf("  mov [rax], dil\n")
+	} else if sizeOf(ty) == 2 {
+		fmt.Printf("  mov [rax], di\n")
+	} else if sizeOf(ty) == 4 {
+		fmt.Printf("  mov [rax], edi\n")
 	} else {
 		fmt.Printf("  mov [rax], rdi\n")
 	}
 	fmt.Printf("  push rdi\n")
 }
 
+// extractBitfield replaces the storage unit on the stack top with the
+// sign- or zero-extended value of bit-field m.
+func extractBitfield(m *member) {
+	fmt.Printf("  pop rax\n")
+	fmt.Printf("  shl rax, %d\n", 64-m.bitWidth-m.bitOffset)
+	if m.ty.isUnsigned {
+		fmt.Printf("  shr rax, %d\n", 64-m.bitWidth)
+	} else {
+		fmt.Printf("  sar rax, %d\n", 64-m.bitWidth)
+	}
+	fmt.Printf("  push rax\n")
+}
+
+func storeBitfield(m *member) {
+	mask := uint64(1)<<uint(m.bitWidth) - 1
+	fmt.Printf("  pop rdi\n")
+	fmt.Printf("  pop rax\n")
+	fmt.Printf("  push rdi\n")
+	fmt.Printf("  mov r8, %d\n", int64(mask))
+	fmt.Printf("  and rdi, r8\n")
+	fmt.Printf("  shl rdi, %d\n", m.bitOffset)
+	if sizeOf(m.ty) == 1 {
+		fmt.Printf("  movzx rdx, byte ptr [rax]\n")
+	} else {
+		fmt.Printf("  mov rdx, [rax]\n")
+	}
+	fmt.Printf("  mov r8, %d\n", int64(^(mask << uint(m.bitOffset))))
+	fmt.Printf("  and rdx, r8\n")
+	fmt.Printf("  or rdx, rdi\n")
+	if sizeOf(m.ty) == 1 {
+		fmt.Printf("  mov [rax], dl\n")
+	} else {
+		fmt.Printf("  mov [rax], rdx\n")
+	}
+	fmt.Printf("  pop rax\n")
+	fmt.Printf("  shl rax, %d\n", 64-m.bitWidth)
+	if m.ty.isUnsigned {
+		fmt.Printf("  shr rax, %d\n", 64-m.bitWidth)
+	} else {
+		fmt.Printf("  sar rax, %d\n", 64-m.bitWidth)
+	}
+	fmt.Printf("  push rax\n")
+}
+
+func truncate(ty *typ) {
+	fmt.Printf("  pop rax\n")
+	if sizeOf(ty) == 1 && ty.isUnsigned {
+		fmt.Printf("  movzx rax, al\n")
+	} else if sizeOf(ty) == 1 {
+		fmt.Printf("  movsx rax, al\n")
+	} else if sizeOf(ty) == 2 && ty.isUnsigned {
+		fmt.Printf("  movzx rax, ax\n")
+	} else if sizeOf(ty) == 2 {
+		fmt.Printf("  movsx rax, ax\n")
+	} else if sizeOf(ty) == 4 && ty.isUnsigned {
+		fmt.Printf("  mov eax, eax\n")
+	} else if sizeOf(ty) == 4 {
+		fmt.Printf("  movsxd rax, eax\n")
+	}
+	fmt.Printf("  push rax\n")
+}
+
+func genCast(from *typ, to *typ) {
+	if !isFlonum(from) && !isFlonum(to) {
+		truncate(to)
+		return
+	}
+	fmt.Printf("  pop rax\n")
+	if isFlonum(from) && isFlonum(to) {
+		if from.kind != to.kind {
+			fmt.Printf("  movq xmm0, rax\n")
+			fmt.Printf("  cvt%s2%s xmm0, xmm0\n", flonumSuffix(from), flonumSuffix(to))
+			fmt.Printf("  movq rax, xmm0\n")
+		}
+	} else if isFlonum(to) && isUnsigned64(from) {
+		genU64ToFlonum(to)
+	} else if isFlonum(to) {
+		fmt.Printf("  cvtsi2%s xmm0, rax\n", flonumSuffix(to))
+		fmt.Printf("  movq rax, xmm0\n")
+	} else if isUnsigned64(to) {
+		genFlonumToU64(from)
+	} else {
+		fmt.Printf("  movq xmm0, rax\n")
+		fmt.Printf("  cvtt%s2si rax, xmm0\n", flonumSuffix(from))
+		fmt.Printf("  push rax\n")
+		truncate(to)
+		return
+	}
+	fmt.Printf("  push rax\n")
+}
+
+func isUnsigned64(ty *typ) bool {
+	return ty.isUnsigned && sizeOf(ty) == 8
+}
+
+// genU64ToFlonum converts the unsigned value in rax. cvtsi2sd only
+// takes signed operands, so values of 2^63 or more are halved, keeping
+// the low bit for rounding, converted and then doubled.
+func genU64ToFlonum(to *typ) {
+	seq := labelSeq
+	labelSeq++
+	sfx := flonumSuffix(to)
+	fmt.Printf("  test rax, rax\n")
+	fmt.Printf("  js .Lu2f.big%d\n", seq)
+	fmt.Printf("  cvtsi2%s xmm0, rax\n", sfx)
+	fmt.Printf("  jmp .Lu2f.end%d\n", seq)
+	fmt.Printf(".Lu2f.big%d:\n", seq)
+	fmt.Printf("  mov rdi, rax\n")
+	fmt.Printf("  shr rdi, 1\n")
+	fmt.Printf("  and eax, 1\n")
+	fmt.Printf("  or rdi, rax\n")
+	fmt.Printf("  cvtsi2%s xmm0, rdi\n", sfx)
+	fmt.Printf("  add%s xmm0, xmm0\n", sfx)
+	fmt.Printf(".Lu2f.end%d:\n", seq)
+	fmt.Printf("  movq rax, xmm0\n")
+}
+
+// genFlonumToU64 converts the floating-point value in rax to an
+// unsigned 64-bit integer. Values of 2^63 or more are reduced by 2^63
+// before the signed conversion and the top bit is set afterwards.
+func genFlonumToU64(from *typ) {
+	seq := labelSeq
+	labelSeq++
+	sfx := flonumSuffix(from)
+	fmt.Printf("  movq xmm0, rax\n")
+	if from.kind == tyFloat {
+		fmt.Printf("  mov eax, 0x%x\n", math.Float32bits(1<<63))
+	} else {
+		fmt.Printf("  mov rax, 0x%x\n", math.Float64bits(1<<63))
+	}
+	fmt.Printf("  movq xmm1, rax\n")
+	fmt.Printf("  ucomi%s xmm0, xmm1\n", sfx)
+	fmt.Printf("  jae .Lf2u.big%d\n", seq)
+	fmt.Printf("  cvtt%s2si rax, xmm0\n", sfx)
+	fmt.Printf("  jmp .Lf2u.end%d\n", seq)
+	fmt.Printf(".Lf2u.big%d:\n", seq)
+	fmt.Printf("  sub%s xmm0, xmm1\n", sfx)
+	fmt.Printf("  cvtt%s2si rax, xmm0\n", sfx)
+	fmt.Printf("  btc rax, 63\n")
+	fmt.Printf(".Lf2u.end%d:\n", seq)
+}
+
+// genCond evaluates n and compares it against zero.
+func genCond(n *node) {
+	gen(n)
+	fmt.Printf("  pop rax\n")
+	if isFlonum(n.ty) {
+		fmt.Printf("  movq xmm0, rax\n")
+		fmt.Printf("  xorps xmm1, xmm1\n")
+		fmt.Printf("  ucomi%s xmm0, xmm1\n", flonumSuffix(n.ty))
+		fmt.Printf("  setne al\n")
+		fmt.Printf("  setp dl\n")
+		fmt.Printf("  or al, dl\n")
+		fmt.Printf("  movzb rax, al\n")
+	}
+	fmt.Printf("  cmp rax, 0\n")
+}
+
+func scale(ty *typ) {
+	if ty.kind == tyVla {
+		fmt.Printf("  imul rdi, [rbp-%d]\n", ty.vlaSize.offset)
+	} else {
+		fmt.Printf("  imul rdi, %d\n", sizeOf(ty))
+	}
+}
+
+// genAlloca carves a 16-byte aligned block out of the stack just below the
+// previously allocated ones, moving the pending temporaries of the stack
+// machine down to make room for it.
+func genAlloca(n *node) {
+	seq := labelSeq
+	labelSeq++
+	base := curFn.allocaBase.offset
+	gen(n.lhs)
+	fmt.Printf("  pop rax\n")
+	fmt.Printf("  mov rcx, [rbp-%d]\n", base)
+	fmt.Printf("  mov rdi, rcx\n")
+	fmt.Printf("  sub rdi, rax\n")
+	fmt.Printf("  and rdi, -16\n")
+	fmt.Printf("  mov rax, rcx\n")
+	fmt.Printf("  sub rax, rdi\n")
+	fmt.Printf("  mov rdx, rsp\n")
+	fmt.Printf("  sub rsp, rax\n")
+	fmt.Printf("  mov rsi, rsp\n")
+	fmt.Printf(".Lalloca.begin%d:\n", seq)
+	fmt.Printf("  cmp rdx, rcx\n")
+	fmt.Printf("  je .Lalloca.end%d\n", seq)
+	fmt.Printf("  mov r8, [rdx]\n")
+	fmt.Printf("  mov [rsi], r8\n")
+	fmt.Printf("  add rdx, 8\n")
+	fmt.Printf("  add rsi, 8\n")
+	fmt.Printf("  jmp .Lalloca.begin%d\n", seq)
+	fmt.Printf(".Lalloca.end%d:\n", seq)
+	fmt.Printf("  mov [rbp-%d], rdi\n", base)
+	fmt.Printf("  push rdi\n")
+}
+
+// genVlaFree releases the blocks allocated since the base saved in n.v was
+// recorded, moving the pending temporaries back up.
+func genVlaFree(n *node) {
+	seq := labelSeq
+	labelSeq++
+	base := curFn.allocaBase.offset
+	fmt.Printf("  mov rcx, [rbp-%d]\n", base)
+	fmt.Printf("  mov rax, [rbp-%d]\n", n.v.offset)
+	fmt.Printf(".Lvla.begin%d:\n", seq)
+	fmt.Printf("  cmp rcx, rsp\n")
+	fmt.Printf("  je .Lvla.end%d\n", seq)
+	fmt.Printf("  sub rcx, 8\n")
+	fmt.Printf("  sub rax, 8\n")
+	fmt.Printf("  mov r8, [rcx]\n")
+	fmt.Printf("  mov [rax], r8\n")
+	fmt.Printf("  jmp .Lvla.begin%d\n", seq)
+	fmt.Printf(".Lvla.end%d:\n", seq)
+	fmt.Printf("  mov rsp, rax\n")
+	fmt.Printf("  mov rax, [rbp-%d]\n", n.v.offset)
+	fmt.Printf("  mov [rbp-%d], rax\n", base)
+}
+
+func genFloatBinary(n *node) {
+	sfx := flonumSuffix(n.lhs.ty)
+	fmt.Printf("  pop rdi\n")
+	fmt.Printf("  pop rax\n")
+	fmt.Printf("  movq xmm0, rax\n")
+	fmt.Printf("  movq xmm1, rdi\n")
+	switch n.kind {
+	case ndAdd:
+		fmt.Printf("  add%s xmm0, xmm1\n", sfx)
+	case ndSub:
+		fmt.Printf("  sub%s xmm0, xmm1\n", sfx)
+	case ndMul:
+		fmt.Printf("  mul%s xmm0, xmm1\n", sfx)
+	case ndDiv:
+		fmt.Printf("  div%s xmm0, xmm1\n", sfx)
+	case ndEq:
+		fmt.Printf("  ucomi%s xmm0, xmm1\n", sfx)
+		fmt.Printf("  sete al\n")
+		fmt.Printf("  setnp dl\n")
+		fmt.Printf("  and al, dl\n")
+	case ndNe:
+		fmt.Printf("  ucomi%s xmm0, xmm1\n", sfx)
+		fmt.Printf("  setne al\n")
+		fmt.Printf("  setp dl\n")
+		fmt.Printf("  or al, dl\n")
+	case ndLt:
+		fmt.Printf("  ucomi%s xmm1, xmm0\n", sfx)
+		fmt.Printf("  seta al\n")
+	case ndLe:
+		fmt.Printf("  ucomi%s xmm1, xmm0\n", sfx)
+		fmt.Printf("  setae al\n")
+	}
+	if isFlonum(n.ty) {
+		fmt.Printf("  movq rax, xmm0\n")
+	} else {
+		fmt.Printf("  movzb rax, al\n")
+	}
+	fmt.Printf("  push rax\n")
+}
+
+// gpRegs lists the general-purpose registers by their 8, 4, 2 and 1 byte
+// names. Entries 0 to 5 match the asm constraint letters in asmFixedRegs.
+var gpRegs = [...][4]string{
+	{"rax", "eax", "ax", "al"},
+	{"rbx", "ebx", "bx", "bl"},
+	{"rcx", "ecx", "cx", "cl"},
+	{"rdx", "edx", "dx", "dl"},
+	{"rsi", "esi", "si", "sil"},
+	{"rdi", "edi", "di", "dil"},
+	{"r8", "r8d", "r8w", "r8b"},
+	{"r9", "r9d", "r9w", "r9b"},
+	{"r10", "r10d", "r10w", "r10b"},
+	{"r11", "r11d", "r11w", "r11b"},
+	{"r12", "r12d", "r12w", "r12b"},
+	{"r13", "r13d", "r13w", "r13b"},
+	{"r14", "r14d", "r14w", "r14b"},
+	{"r15", "r15d", "r15w", "r15b"},
+}
+
+const asmFixedRegs = "abcdSD"
+
+// asmRegPool is the order in which registers are handed out to "r"
+// operands. Callee-saved registers come last since using them costs a
+// save and restore.
+var asmRegPool = [...]int{8, 9, 6, 7, 4, 5, 2, 3, 0, 1, 10, 11, 12, 13}
+
+func isCalleeSaved(r int) bool {
+	return r == 1 || r >= 10
+}
+
+func regIndex(name []rune) int {
+	for i, names := range gpRegs {
+		for _, s := range names {
+			if s == string(name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func regName(r int, sz int) string {
+	switch sz {
+	case 1:
+		return gpRegs[r][3]
+	case 2:
+		return gpRegs[r][2]
+	case 4:
+		return gpRegs[r][1]
+	}
+	return gpRegs[r][0]
+}
+
+func ptrName(sz int) string {
+	switch sz {
+	case 1:
+		return "BYTE PTR "
+	case 2:
+		return "WORD PTR "
+	case 4:
+		return "DWORD PTR "
+	case 8:
+		return "QWORD PTR "
+	}
+	return ""
+}
+
+// asmKind reduces the constraint of op to the alternative we implement:
+// 'i' for an immediate, 'r' for any register, 'm' for memory, one of
+// asmFixedRegs for a specific register or a digit for a matching operand.
+func asmKind(op *asmOperand) rune {
+	c := string(op.constraint)
+	if i := strings.IndexAny(c, "0123456789"); i >= 0 && !op.isOutput {
+		return rune(c[i])
+	}
+	if i := strings.IndexAny(c, asmFixedRegs); i >= 0 {
+		return rune(c[i])
+	}
+	if strings.ContainsAny(c, "in") && !op.isOutput && isConstExpr(op.expr) {
+		return 'i'
+	}
+	if strings.ContainsAny(c, "rgq") {
+		return 'r'
+	}
+	if strings.ContainsRune(c, 'm') {
+		return 'm'
+	}
+	if strings.ContainsAny(c, "in") {
+		errorTok(op.expr.tok, "impossible constraint in asm")
+	}
+	errorTok(op.expr.tok, "invalid constraint '%s' in asm", op.constraint)
+	return 0
+}
+
+// genAsm emits an asm statement. Operands are evaluated onto the stack in
+// order, loaded into the registers chosen for them, and register outputs
+// are stored back through their addresses once the template has run.
+func genAsm(n *node) {
+	a := n.asm
+	if !a.isExtended {
+		fmt.Printf("%s\n", string(a.str))
+		return
+	}
+	var ops []*asmOperand
+	for op := a.ops; op != nil; op = op.next {
+		ops = append(ops, op)
+	}
+	used := make([]bool, len(gpRegs))
+	for _, c := range a.clobbers {
+		if r := regIndex(c); r >= 0 {
+			used[r] = true
+		} else if string(c) != "cc" && string(c) != "memory" {
+			errorTok(n.tok, "unknown register name '%s' in asm", c)
+		}
+	}
+	// Inputs are loaded before the template runs and outputs stored
+	// after it, so an input and an output may share a fixed register
+	// unless the output is early-clobbered.
+	clobbered := append([]bool(nil), used...)
+	in := make([]bool, len(gpRegs))
+	out := make([]bool, len(gpRegs))
+	early := make([]bool, len(gpRegs))
+	kind := make([]rune, len(ops))
+	reg := make([]int, len(ops))
+	for i, op := range ops {
+		kind[i] = asmKind(op)
+		reg[i] = -1
+		r := strings.IndexRune(asmFixedRegs, kind[i])
+		if r < 0 {
+			continue
+		}
+		isIn := !op.isOutput || op.constraint[0] == '+'
+		isEarly := op.isOutput && strings.ContainsRune(string(op.constraint), '&')
+		if clobbered[r] || isIn && (in[r] || early[r]) || op.isOutput && (out[r] || isEarly && in[r]) {
+			errorTok(op.expr.tok, "asm operand conflicts with another operand or clobber")
+		}
+		in[r] = in[r] || isIn
+		out[r] = out[r] || op.isOutput
+		early[r] = early[r] || isEarly
+		reg[i] = r
+		used[r] = true
+	}
+	alloc := func(tok *token) int {
+		for _, r := range asmRegPool {
+			if !used[r] {
+				used[r] = true
+				return r
+			}
+		}
+		errorTok(tok, "asm operands need more registers than are available")
+		return -1
+	}
+	for i, op := range ops {
+		if kind[i] == 'r' || kind[i] == 'm' && !isLocalVar(op.expr) {
+			reg[i] = alloc(op.expr.tok)
+		}
+	}
+	for i, op := range ops {
+		if kind[i] < '0' || kind[i] > '9' {
+			continue
+		}
+		j := int(kind[i] - '0')
+		if j >= len(ops) || !ops[j].isOutput || kind[j] == 'm' {
+			errorTok(op.expr.tok, "matching constraint does not refer to a register output")
+		}
+		reg[i] = reg[j]
+	}
+	scratch := -1
+	for i, op := range ops {
+		if op.isOutput && kind[i] != 'm' && (!isScalar(op.expr.ty) || sizeOf(op.expr.ty) > 8) {
+			errorTok(op.expr.tok, "invalid lvalue in asm output")
+		}
+		if op.isOutput && kind[i] != 'm' && scratch < 0 {
+			scratch = alloc(n.tok)
+		}
+	}
+	var saved []int
+	for _, r := range asmRegPool {
+		if used[r] && isCalleeSaved(r) {
+			fmt.Printf("  push %s\n", gpRegs[r][0])
+			saved = append(saved, r)
+		}
+	}
+	slot := make([]int, len(ops))
+	nslot := 0
+	for i, op := range ops {
+		slot[i] = -1
+		switch {
+		case kind[i] == 'i':
+			continue
+		case kind[i] == 'm' && reg[i] < 0:
+			continue
+		case op.isOutput || kind[i] == 'm':
+			genAddr(op.expr)
+		default:
+			gen(op.expr)
+		}
+		slot[i] = nslot
+		nslot++
+	}
+	for i, op := range ops {
+		if reg[i] < 0 || op.isOutput && kind[i] != 'm' && op.constraint[0] != '+' {
+			continue
+		}
+		r := gpRegs[reg[i]][0]
+		fmt.Printf("  mov %s, [rsp+%d]\n", r, 8*(nslot-1-slot[i]))
+		if op.isOutput && kind[i] != 'm' {
+			fmt.Printf("  mov %s, [%s]\n", regName(reg[i], sizeOf(op.expr.ty)), r)
+		}
+	}
+	fmt.Printf("  %s\n", asmTemplate(n, ops, kind, reg))
+	for i, op := range ops {
+		if !op.isOutput || kind[i] == 'm' {
+			continue
+		}
+		fmt.Printf("  mov %s, [rsp+%d]\n", gpRegs[scratch][0], 8*(nslot-1-slot[i]))
+		fmt.Printf("  mov [%s], %s\n", gpRegs[scratch][0], regName(reg[i], sizeOf(op.expr.ty)))
+	}
+	if nslot > 0 {
+		fmt.Printf("  add rsp, %d\n", 8*nslot)
+	}
+	for i := len(saved) - 1; i >= 0; i-- {
+		fmt.Printf("  pop %s\n", gpRegs[saved[i]][0])
+	}
+}
+
+func isLocalVar(n *node) bool {
+	return n.kind == ndVar && n.v.isLocal && n.v.ty.kind != tyVla
+}
+
+// asmTemplate substitutes the operands into the template of n. %N and
+// %[name] refer to an operand, optionally preceded by one of the size
+// modifiers b, w, k and q, and %% is a literal percent sign.
+func asmTemplate(n *node, ops []*asmOperand, kind []rune, reg []int) string {
+	s := n.asm.str
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteRune(s[i])
+			continue
+		}
+		i++
+		if i < len(s) && s[i] == '%' {
+			b.WriteRune('%')
+			continue
+		}
+		sz := 0
+		if i+1 < len(s) && strings.ContainsRune("bwkq", s[i]) && (isDigit(s[i+1]) || s[i+1] == '[') {
+			sz = map[rune]int{'b': 1, 'w': 2, 'k': 4, 'q': 8}[s[i]]
+			i++
+		}
+		idx := -1
+		if i < len(s) && s[i] == '[' {
+			j := i + 1
+			for j < len(s) && s[j] != ']' {
+				j++
+			}
+			name := string(s[i+1 : j])
+			for k, op := range ops {
+				if op.name != nil && string(op.name) == name {
+					idx = k
+				}
+			}
+			if idx < 0 {
+				errorTok(n.tok, "undefined named operand '%s'", []rune(name))
+			}
+			i = j
+		} else if i < len(s) && isDigit(s[i]) {
+			idx = 0
+			for ; i < len(s) && isDigit(s[i]); i++ {
+				idx = idx*10 + int(s[i]-'0')
+			}
+			i--
+			if idx >= len(ops) {
+				errorTok(n.tok, "operand number out of range")
+			}
+		} else {
+			errorTok(n.tok, "invalid '%%' in asm template")
+		}
+		op := ops[idx]
+		if sz == 0 {
+			sz = sizeOf(op.expr.ty)
+		}
+		switch {
+		case kind[idx] == 'i':
+			fmt.Fprintf(&b, "%d", eval(op.expr))
+		case kind[idx] == 'm' && reg[idx] < 0:
+			fmt.Fprintf(&b, "%s[rbp-%d]", ptrName(sz), op.expr.v.offset)
+		case kind[idx] == 'm':
+			fmt.Fprintf(&b, "%s[%s]", ptrName(sz), gpRegs[reg[idx]][0])
+		default:
+			b.WriteString(regName(reg[idx], sz))
+		}
+	}
+	return b.String()
+}
+
 func gen(n *node) {
 	switch n.kind {
 	case ndNull:
 		return
 	case ndNum:
-		fmt.Printf("  push %d\n", n.val)
+		if n.ty.kind == tyFloat {
+			fmt.Printf("  mov rax, %d\n", math.Float32bits(float32(n.fval)))
+			fmt.Printf("  push rax\n")
+		} else if n.ty.kind == tyDouble {
+			fmt.Printf("  mov rax, %d\n", math.Float64bits(n.fval))
+			fmt.Printf("  push rax\n")
+		} else if n.val != int(int32(n.val)) {
+			fmt.Printf("  mov rax, %d\n", n.val)
+			fmt.Printf("  push rax\n")
+		} else {
+			fmt.Printf("  push %d\n", n.val)
+		}
 		return
 	case ndExprStmt:
 		gen(n.lhs)
 		fmt.Printf("  add rsp, 8\n")
 		return
 	case ndVar:
+		fallthrough
+	case ndVlaPtr:
+		fallthrough
+	case ndMember:
 		genAddr(n)
-		if n.ty.kind != tyArray {
+		if isScalar(n.ty) {
 			load(n.ty)
 		}
+		if isBitfield(n) {
+			extractBitfield(n.member)
+		}
 		return
 	case ndAssign:
 		genLval(n.lhs)
 		gen(n.rhs)
-		store(n.ty)
+		if isBitfield(n.lhs) {
+			storeBitfield(n.lhs.member)
+		} else {
+			store(n.ty)
+		}
 		return
 	case ndAddr:
 		genAddr(n.lhs)
 		return
 	case ndDeref:
 		gen(n.lhs)
-		if n.ty.kind != tyArray {
+		if isScalar(n.ty) {
 			load(n.ty)
 		}
 		return
@@ -91,9 +936,7 @@ func gen(n *node) {
 		seq := labelSeq
 		labelSeq++
 		if n.els != nil {
-			gen(n.cond)
-			fmt.Printf("  pop rax\n")
-			fmt.Printf("  cmp rax, 0\n")
+			genCond(n.cond)
 			fmt.Printf("  je .Lelse%d\n", seq)
 			gen(n.then)
 			fmt.Printf("  jmp .Lend%d\n", seq)
@@ -101,21 +944,57 @@ func gen(n *node) {
 			gen(n.els)
 			fmt.Printf(".Lend%d:\n", seq)
 		} else {
-			gen(n.cond)
-			fmt.Printf("  pop rax\n")
-			fmt.Printf("  cmp rax, 0\n")
+			genCond(n.cond)
 			fmt.Printf("  je .Lend%d\n", seq)
 			gen(n.then)
 			fmt.Printf(".Lend%d:\n", seq)
 		}
 		return
+	case ndCond:
+		seq := labelSeq
+		labelSeq++
+		genCond(n.cond)
+		fmt.Printf("  je .Lelse%d\n", seq)
+		gen(n.then)
+		fmt.Printf("  jmp .Lend%d\n", seq)
+		fmt.Printf(".Lelse%d:\n", seq)
+		gen(n.els)
+		fmt.Printf(".Lend%d:\n", seq)
+		return
+	case ndCast:
+		gen(n.lhs)
+		genCast(n.lhs.ty, n.ty)
+		return
+	case ndVaArg:
+		seq := labelSeq
+		labelSeq++
+		field, limit, step := 0, 48, 8
+		if isFlonum(n.ty) {
+			field, limit, step = 4, 176, 16
+		}
+		gen(n.lhs)
+		fmt.Printf("  pop rcx\n")
+		fmt.Printf("  mov eax, [rcx+%d]\n", field)
+		fmt.Printf("  cmp eax, %d\n", limit)
+		fmt.Printf("  jae .Lva.overflow%d\n", seq)
+		fmt.Printf("  mov rdx, [rcx+16]\n")
+		fmt.Printf("  add rdx, rax\n")
+		fmt.Printf("  add eax, %d\n", step)
+		fmt.Printf("  mov [rcx+%d], eax\n", field)
+		fmt.Printf("  jmp .Lva.end%d\n", seq)
+		fmt.Printf(".Lva.overflow%d:\n", seq)
+		fmt.Printf("  mov rdx, [rcx+8]\n")
+		fmt.Printf("  lea rax, [rdx+8]\n")
+		fmt.Printf("  mov [rcx+8], rax\n")
+		fmt.Printf(".Lva.end%d:\n", seq)
+		fmt.Printf("  push rdx\n")
+		load(n.ty)
+		return
 	case ndWhile:
 		seq := labelSeq
 		labelSeq++
 		fmt.Printf(".Lbegin%d:\n", seq)
-		gen(n.cond)
-		fmt.Printf("  pop rax\n")
-		fmt.Printf("  cmp rax, 0\n")
+		genCond(n.cond)
 		fmt.Printf("  je .Lend%d\n", seq)
 		gen(n.then)
 		fmt.Printf("  jmp .Lbegin%d\n", seq)
@@ -129,9 +1008,7 @@ func gen(n *node) {
 		}
 		fmt.Printf(".Lbegin%d:\n", seq)
 		if n.cond != nil {
-			gen(n.cond)
-			fmt.Printf("  pop rax\n")
-			fmt.Printf("  cmp rax, 0\n")
+			genCond(n.cond)
 			fmt.Printf("  je .Lend%d\n", seq)
 		}
 		gen(n.then)
@@ -142,63 +1019,88 @@ func gen(n *node) {
 		fmt.Printf(".Lend%d:\n", seq)
 		return
 	case ndBlock:
+		fallthrough
+	case ndStmtExpr:
 		for b := n.body; b != nil; b = b.next {
 			gen(b)
 		}
 		return
 	case ndFunCall:
-		c := 0
-		for arg := n.args; arg != nil; arg = arg.next {
-			gen(arg)
-			c++
-		}
-		for c > 0 {
-			c--
-			fmt.Printf("  pop %s\n", argreg8[c])
-		}
-		seq := labelSeq
-		labelSeq++
-		fmt.Printf("  mov rax, rsp\n")
-		fmt.Printf("  and rax, 15\n")
-		fmt.Printf("  jnz .Lcall%d\n", seq)
-		fmt.Printf("  mov rax, 0\n")
-		fmt.Printf("  call %s\n", string(n.funcname))
-		fmt.Printf("  jmp .Lend%d\n", seq)
-		fmt.Printf(".Lcall%d:\n", seq)
-		fmt.Printf("  sub rsp, 8\n")
-		fmt.Printf("  mov rax, 0\n")
-		fmt.Printf("  call %s\n", string(n.funcname))
-		fmt.Printf("  add rsp, 8\n")
-		fmt.Printf(".Lend%d:\n", seq)
-		fmt.Printf("  push rax\n")
+		genFunCall(n)
+		return
+	case ndAlloca:
+		genAlloca(n)
+		return
+	case ndVlaFree:
+		genVlaFree(n)
+		return
+	case ndAsm:
+		genAsm(n)
 		return
 	case ndRet:
 		gen(n.lhs)
+		ty := curFn.ty.returnTy
+		if isScalar(ty) {
+			genCast(n.lhs.ty, ty)
+		}
 		fmt.Printf("  pop rax\n")
-		fmt.Printf("  jmp .Lreturn.%s\n", string(funcname))
+		if isFlonum(ty) {
+			fmt.Printf("  movq xmm0, rax\n")
+		} else if isMemoryClass(ty) {
+			fmt.Printf("  mov rdi, [rbp-%d]\n", curFn.retPtr.offset)
+			for i := 0; i < sizeOf(ty); i++ {
+				fmt.Printf("  mov r8b, [rax+%d]\n", i)
+				fmt.Printf("  mov [rdi+%d], r8b\n", i)
+			}
+			fmt.Printf("  mov rax, rdi\n")
+		} else if ty.kind == tyStruct {
+			fmt.Printf("  mov rdi, rax\n")
+			ret := [2]string{"rax", "rdx"}
+			sse, _, _ := classify(ty)
+			gi, fi := 0, 0
+			for i, f := range sse {
+				if f {
+					fmt.Printf("  movsd xmm%d, [rdi+%d]\n", fi, 8*i)
+					fi++
+				} else {
+					fmt.Printf("  mov %s, [rdi+%d]\n", ret[gi], 8*i)
+					gi++
+				}
+			}
+		}
+		fmt.Printf("  jmp .Lreturn.%s\n", string(curFn.name))
 		return
 	}
 	gen(n.lhs)
 	gen(n.rhs)
+	if isFlonum(n.lhs.ty) {
+		genFloatBinary(n)
+		return
+	}
 	fmt.Printf("  pop rdi\n")
 	fmt.Printf("  pop rax\n")
 
 	switch n.kind {
 	case ndAdd:
 		if n.ty.base != nil {
-			fmt.Printf("  imul rdi, %d\n", sizeOf(n.ty.base))
+			scale(n.ty.base)
 		}
 		fmt.Printf("  add rax, rdi\n")
 	case ndSub:
 		if n.ty.base != nil {
-			fmt.Printf("  imul rdi, %d\n", sizeOf(n.ty.base))
+			scale(n.ty.base)
 		}
 		fmt.Printf("  sub rax, rdi\n")
 	case ndMul:
 		fmt.Printf("  imul rax, rdi\n")
 	case ndDiv:
-		fmt.Printf("  cqo\n")
-		fmt.Printf("  idiv rdi\n")
+		if isUnsignedOp(n) {
+			fmt.Printf("  mov rdx, 0\n")
+			fmt.Printf("  div rdi\n")
+		} else {
+			fmt.Printf("  cqo\n")
+			fmt.Printf("  idiv rdi\n")
+		}
 	case ndEq:
 		fmt.Printf("  cmp rax, rdi\n")
 		fmt.Printf("  sete al\n")
@@ -209,61 +1111,272 @@ func gen(n *node) {
 		fmt.Printf("  movzb rax, al\n")
 	case ndLt:
 		fmt.Printf("  cmp rax, rdi\n")
-		fmt.Printf("  setl al\n")
+		if isUnsignedOp(n) {
+			fmt.Printf("  setb al\n")
+		} else {
+			fmt.Printf("  setl al\n")
+		}
 		fmt.Printf("  movzb rax, al\n")
 	case ndLe:
 		fmt.Printf("  cmp rax, rdi\n")
-		fmt.Printf("  setle al\n")
+		if isUnsignedOp(n) {
+			fmt.Printf("  setbe al\n")
+		} else {
+			fmt.Printf("  setle al\n")
+		}
 		fmt.Printf("  movzb rax, al\n")
 	}
 	fmt.Printf("  push rax\n")
 }
 
+func emitInitializer(init *initializer) {
+	for ; init != nil; init = init.next {
+		if init.label != nil {
+			fmt.Printf("  .quad %s%+d\n", string(init.label), init.addend)
+			continue
+		}
+		switch init.sz {
+		case 1:
+			fmt.Printf("  .byte %d\n", int8(init.val))
+		case 2:
+			fmt.Printf("  .short %d\n", int16(init.val))
+		case 4:
+			fmt.Printf("  .long %d\n", int32(init.val))
+		case 8:
+			fmt.Printf("  .quad %d\n", init.val)
+		default:
+			fmt.Printf("  .zero %d\n", init.sz)
+		}
+	}
+}
+
+func emitBinding(name []rune, isStatic bool, isWeak bool) {
+	if isWeak {
+		fmt.Printf(".weak %s\n", string(name))
+	} else if !isStatic {
+		fmt.Printf(".global %s\n", string(name))
+	}
+}
+
+func emitLabel(v *va) {
+	emitBinding(v.name, v.isStatic, v.isWeak)
+	fmt.Printf("%s:\n", string(v.name))
+}
+
+func emitVar(v *va) {
+	fmt.Printf(".align %d\n", varAlign(v))
+	emitLabel(v)
+	if v.contents != nil {
+		dir := map[int]string{1: ".byte", 2: ".short", 4: ".long"}[sizeOf(v.ty.base)]
+		for _, c := range v.contents {
+			fmt.Printf("  %s %d\n", dir, c)
+		}
+	} else if v.initializer != nil {
+		emitInitializer(v.initializer)
+	} else {
+		fmt.Printf("  .zero %d\n", sizeOf(v.ty))
+	}
+}
+
 func emitData(p *prog) {
-	fmt.Printf(".data\n")
+	emitVars(p, false)
+	emitVars(p, true)
 	for vl := p.globals; vl != nil; vl = vl.next {
 		v := vl.v
-		fmt.Printf("%s:\n", string(v.name))
-		if v.contents != nil {
-			for _, b := range v.contents {
-				fmt.Printf("  .byte %d\n", b)
-			}
-		} else {
-			fmt.Printf("  .zero %d\n", sizeOf(v.ty))
+		if v.section == nil || v.alias != nil || v.isExtern || v.ty.kind == tyFunc {
+			continue
+		}
+		flags := "aw"
+		if v.isTls {
+			flags = "awT"
+		}
+		fmt.Printf(".section %s,\"%s\",@progbits\n", string(v.section), sectionFlags(flags, v.isUsed))
+		emitVar(v)
+	}
+	for vl := p.globals; vl != nil; vl = vl.next {
+		v := vl.v
+		if v.alias != nil {
+			emitBinding(v.name, v.isStatic, v.isWeak)
+			fmt.Printf(".set %s, %s\n", string(v.name), string(v.alias))
+		} else if v.isWeak {
+			// Weak references to symbols not defined here still need
+			// the binding.
+			fmt.Printf(".weak %s\n", string(v.name))
+		}
+		if v.isCtor {
+			emitFuncArray(".init_array", v.ctorPrio, v.name)
+		}
+		if v.isDtor {
+			emitFuncArray(".fini_array", v.dtorPrio, v.name)
 		}
 	}
 }
 
-func loadArg(v *va, idx int) {
-	sz := sizeOf(v.ty)
-	if sz == 1 {
-		fmt.Printf("  mov [rbp-%d], %s\n", v.offset, argreg1[idx])
+// sectionFlags adds the retain flag to flags for used symbols so that
+// the linker does not discard their section.
+func sectionFlags(flags string, isUsed bool) string {
+	if isUsed {
+		return flags + "R"
+	}
+	return flags
+}
+
+// emitFuncArray adds a pointer to the function name to the .init_array
+// or .fini_array section, in the subsection for its priority if any.
+func emitFuncArray(sec string, prio int, name []rune) {
+	if prio != 0 {
+		sec = fmt.Sprintf("%s.%05d", sec, prio)
+	}
+	fmt.Printf(".section %s,\"aw\"\n", sec)
+	fmt.Printf(".align 8\n")
+	fmt.Printf("  .quad %s\n", string(name))
+}
+
+// emitVars emits the global variables that are thread-local or not,
+// depending on tls. Those with their own section are emitted later.
+func emitVars(p *prog, tls bool) {
+	if tls {
+		fmt.Printf(".section .tbss,\"awT\",@nobits\n")
+	} else {
+		fmt.Printf(".bss\n")
+	}
+	for vl := p.globals; vl != nil; vl = vl.next {
+		v := vl.v
+		if v.isTls != tls || v.section != nil || v.alias != nil || v.isExtern || v.ty.kind == tyFunc || v.contents != nil || v.initializer != nil {
+			continue
+		}
+		emitVar(v)
+	}
+	if tls {
+		fmt.Printf(".section .tdata,\"awT\",@progbits\n")
 	} else {
-		fmt.Printf("  mov [rbp-%d], %s\n", v.offset, argreg8[idx])
+		fmt.Printf(".data\n")
+	}
+	for vl := p.globals; vl != nil; vl = vl.next {
+		v := vl.v
+		if v.isTls != tls || v.section != nil || v.alias != nil || v.contents == nil && v.initializer == nil {
+			continue
+		}
+		emitVar(v)
+	}
+}
+
+func loadArgs(fn *fun) (int, int, int) {
+	gp := 0
+	fp := 0
+	stack := 0
+	if fn.frameAlign > 16 {
+		fmt.Printf("  mov r11, [rbp-8]\n")
+	}
+	if fn.retPtr != nil {
+		fmt.Printf("  mov [rbp-%d], rdi\n", fn.retPtr.offset)
+		gp++
+	}
+	for vl := fn.params; vl != nil; vl = vl.next {
+		v := vl.v
+		sz := sizeOf(v.ty)
+		sse, ngp, nfp := classify(v.ty)
+		if isMemoryClass(v.ty) || gp+ngp > len(argreg8) || fp+nfp > 8 {
+			for i := 0; i < sz; i++ {
+				fmt.Printf("  mov al, [%s+%d]\n", argBase(fn), 16+8*stack+i)
+				fmt.Printf("  mov [rbp-%d], al\n", v.offset-i)
+			}
+			stack += len(sse)
+			continue
+		}
+		for i, f := range sse {
+			n := sz - 8*i
+			if n > 8 {
+				n = 8
+			}
+			if f {
+				storeXmm(fp, v.offset-8*i, n)
+				fp++
+			} else {
+				storeGp(argRegs(gp), v.offset-8*i, n)
+				gp++
+			}
+		}
+	}
+	return gp, fp, stack
+}
+
+// argBase returns the register that points at the saved rbp of fn's
+// caller, above which the stack arguments are passed. It is rbp unless
+// the frame has been realigned, in which case loadArgs loads it into r11.
+func argBase(fn *fun) string {
+	if fn.frameAlign > 16 {
+		return "r11"
+	}
+	return "rbp"
+}
+
+func saveVaRegs(fn *fun, gp int, fp int, stack int) {
+	va := fn.vaArea.offset
+	rs := fn.regSaveArea.offset
+	fmt.Printf("  mov dword ptr [rbp-%d], %d\n", va, gp*8)
+	fmt.Printf("  mov dword ptr [rbp-%d], %d\n", va-4, 48+fp*16)
+	fmt.Printf("  lea rax, [%s+%d]\n", argBase(fn), 16+8*stack)
+	fmt.Printf("  mov [rbp-%d], rax\n", va-8)
+	fmt.Printf("  lea rax, [rbp-%d]\n", rs)
+	fmt.Printf("  mov [rbp-%d], rax\n", va-16)
+	for i, r := range argreg8 {
+		fmt.Printf("  mov [rbp-%d], %s\n", rs-8*i, r)
+	}
+	for i := 0; i < 8; i++ {
+		fmt.Printf("  movsd [rbp-%d], xmm%d\n", rs-48-16*i, i)
 	}
 }
 
 func emitText(p *prog) {
 	fmt.Printf(".text\n")
 	for fn := p.fns; fn != nil; fn = fn.next {
-		fmt.Printf(".global %s\n", string(fn.name))
+		if fn.section != nil {
+			fmt.Printf(".section %s,\"%s\",@progbits\n", string(fn.section), sectionFlags("ax", fn.isUsed))
+		}
+		emitBinding(fn.name, fn.isStatic, fn.isWeak)
 		fmt.Printf("%s:\n", string(fn.name))
-		funcname = fn.name
+		curFn = fn
 		fmt.Printf("  push rbp\n")
-		fmt.Printf("  mov rbp, rsp\n")
-		fmt.Printf("  sub rsp, %d\n", fn.stackSize)
-		i := 0
-		for vl := fn.params; vl != nil; vl = vl.next {
-			loadArg(vl.v, i)
-			i++
+		if fn.frameAlign > 16 {
+			fmt.Printf("  mov r11, rsp\n")
+			fmt.Printf("  and rsp, %d\n", -fn.frameAlign)
+			fmt.Printf("  mov rbp, rsp\n")
+			fmt.Printf("  sub rsp, %d\n", fn.stackSize)
+			fmt.Printf("  mov [rbp-8], r11\n")
+		} else {
+			fmt.Printf("  mov rbp, rsp\n")
+			fmt.Printf("  sub rsp, %d\n", fn.stackSize)
+		}
+		if fn.allocaBase != nil {
+			fmt.Printf("  mov [rbp-%d], rsp\n", fn.allocaBase.offset)
+		}
+		gp, fp, stack := loadArgs(fn)
+		if fn.isVariadic {
+			saveVaRegs(fn, gp, fp, stack)
 		}
 		for n := fn.node; n != nil; n = n.next {
 			gen(n)
 		}
-		fmt.Printf(".Lreturn.%s:\n", string(funcname))
-		fmt.Printf("  mov rsp, rbp\n")
-		fmt.Printf("  pop rbp\n")
-		fmt.Printf("  ret\n")
+		fmt.Printf(".Lreturn.%s:\n", string(fn.name))
+		if fn.isNoreturn {
+			// Control never gets here; trap rather than return.
+			fmt.Printf("  ud2\n")
+		} else {
+			if fn.frameAlign > 16 {
+				fmt.Printf("  mov rsp, [rbp-8]\n")
+			} else {
+				fmt.Printf("  mov rsp, rbp\n")
+			}
+			fmt.Printf("  pop rbp\n")
+			fmt.Printf("  ret\n")
+		}
+		if fn.section != nil {
+			fmt.Printf(".text\n")
+		}
+	}
+	for n := p.asms; n != nil; n = n.next {
+		genAsm(n)
 	}
 }
 
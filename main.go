@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -12,6 +13,8 @@ func readFile(path string) string {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	// A UTF-8 byte order mark carries no meaning for us.
+	d = bytes.TrimPrefix(d, []byte("\xef\xbb\xbf"))
 	s := string(d)
 	return s
 }
@@ -29,17 +32,31 @@ func main() {
 	filename = os.Args[1]
 	s := readFile(filename)
 	inpt = s
-	t = tokenize([]rune(s))
+	checkEncoding(s)
+	t = tokenize(normalize(s))
 	p := program()
 	addType(p)
 	for fn := p.fns; fn != nil; fn = fn.next {
+		for vl := fn.locals; vl != nil; vl = vl.next {
+			if vl.v.isLocal {
+				fn.frameAlign = maxAlign(fn.frameAlign, varAlign(vl.v))
+			}
+		}
 		o := 0
+		if fn.frameAlign > 16 {
+			// The frame is realigned in the prologue; the top slot
+			// keeps the stack pointer from before the realignment.
+			o = 8
+		}
 		for vl := fn.locals; vl != nil; vl = vl.next {
 			va := vl.v
-			o += sizeOf(va.ty)
+			if !va.isLocal {
+				continue
+			}
+			o = alignTo(o+sizeOf(va.ty), varAlign(va))
 			vl.v.offset = o
 		}
-		fn.stackSize = alignTo(o, 8)
+		fn.stackSize = alignTo(o, 16)
 	}
 	codegen(p)
 	os.Exit(0)
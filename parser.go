@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"strings"
 )
 
 type nodeKind int
@@ -24,93 +26,268 @@ const (
 	ndWhile
 	ndFor
 	ndSizeOf
+	ndCond
+	ndCast
 	ndBlock
 	ndFunCall
 	ndExprStmt
+	ndStmtExpr
+	ndVaArg
+	ndAlloca
+	ndVlaPtr
+	ndVlaFree
+	ndGeneric
+	ndAsm
+	ndMember
 	ndVar
 	ndNum
 	ndNull
 )
 
 type va struct {
-	name     []rune
-	ty       *typ
-	isLocal  bool
-	contents []rune
-	contLen  int
-	offset   int
+	name        []rune
+	ty          *typ
+	isLocal     bool
+	isStatic    bool
+	isExtern    bool
+	isTls       bool
+	isWeak      bool
+	isNoreturn  bool
+	isUsed      bool
+	isCtor      bool
+	isDtor      bool
+	ctorPrio    int
+	dtorPrio    int
+	section     []rune
+	alias       []rune
+	contents    []rune
+	contLen     int
+	offset      int
+	align       int
+	initializer *initializer
+}
+
+type initializer struct {
+	next   *initializer
+	sz     int
+	val    int
+	label  []rune
+	addend int
 }
 
 type varlist struct {
 	next *varlist
+	name []rune
 	v    *va
 }
 
 type node struct {
-	kind     nodeKind
-	next     *node
-	ty       *typ
-	tok      *token
-	lhs      *node
-	rhs      *node
-	cond     *node
-	then     *node
-	els      *node
-	init     *node
-	inc      *node
-	body     *node
-	funcname []rune
-	args     *node
-	v        *va
-	val      int
+	kind       nodeKind
+	next       *node
+	ty         *typ
+	tok        *token
+	lhs        *node
+	rhs        *node
+	cond       *node
+	then       *node
+	els        *node
+	init       *node
+	inc        *node
+	body       *node
+	funcname   []rune
+	args       *node
+	v          *va
+	val        int
+	fval       float64
+	memberName []rune
+	member     *member
+	retBuf     *va
+	assocs     *genericAssoc
+	asm        *asmStmt
+	isInit     bool
+	isChecked  bool
+}
+
+type genericAssoc struct {
+	next *genericAssoc
+	ty   *typ
+	expr *node
+}
+
+// asmStmt is a GNU asm statement. Operands of an extended asm are
+// substituted into the template at code generation.
+type asmStmt struct {
+	str        []rune
+	ops        *asmOperand
+	clobbers   [][]rune
+	isExtended bool
+}
+
+type asmOperand struct {
+	next       *asmOperand
+	name       []rune
+	constraint []rune
+	expr       *node
+	isOutput   bool
 }
 
 type fun struct {
-	next      *fun
-	name      []rune
-	params    *varlist
-	node      *node
-	locals    *varlist
-	stackSize int
+	next        *fun
+	name        []rune
+	isStatic    bool
+	isVariadic  bool
+	isWeak      bool
+	isNoreturn  bool
+	isUsed      bool
+	section     []rune
+	ty          *typ
+	retPtr      *va
+	vaArea      *va
+	regSaveArea *va
+	allocaBase  *va
+	params      *varlist
+	node        *node
+	locals      *varlist
+	stackSize   int
+	frameAlign  int
 }
 
 type prog struct {
 	globals *varlist
 	fns     *fun
+	asms    *node
+}
+
+type storageClass int
+
+const (
+	scNone storageClass = iota
+	scStatic
+	scExtern
+)
+
+// varAttr holds what the declaration specifiers and attributes say
+// about a variable or function beyond its type.
+type varAttr struct {
+	sc         storageClass
+	align      int
+	isTls      bool
+	isPacked   bool
+	isWeak     bool
+	isNoreturn bool
+	isUsed     bool
+	isCtor     bool
+	isDtor     bool
+	ctorPrio   int
+	dtorPrio   int
+	section    []rune
+	alias      []rune
 }
 
 type typeKind int
 
 const (
 	tyChar typeKind = iota
+	// tyShort and tyInt32 have no type specifier of their own; they are
+	// the element types of the u, U and L string and character literals.
+	tyShort
+	tyInt32
 	tyInt
+	tyFloat
+	tyDouble
 	tyPtr
 	tyArray
+	tyStruct
+	tyFunc
+	tyVla
 )
 
 type typ struct {
-	kind      typeKind
-	base      *typ
-	arraySize int
+	kind         typeKind
+	base         *typ
+	arraySize    int
+	isIncomplete bool
+	members      *member
+	size         int
+	align        int
+	returnTy     *typ
+	params       *paramlist
+	isVariadic   bool
+	vlaLen       *node
+	vlaSize      *va
+	isUnsigned   bool
+	isConst      bool
+	isVolatile   bool
+	isRestrict   bool
+	origin       *typ
+}
+
+type paramlist struct {
+	next *paramlist
+	ty   *typ
+	name []rune
+}
+
+type member struct {
+	next       *member
+	ty         *typ
+	name       []rune
+	offset     int
+	align      int
+	isPacked   bool
+	isBitfield bool
+	bitOffset  int
+	bitWidth   int
+}
+
+type tagscope struct {
+	next *tagscope
+	name []rune
+	ty   *typ
 }
 
 var (
-	locals   *varlist
-	globals  *varlist
-	labelcnt = 0
+	locals     *varlist
+	globals    *varlist
+	tags       *tagscope
+	vaArea     *va
+	allocaBase *va
+	vlaBase    *va
+	labelcnt   = 0
+	inFunction = false
 )
 
+func findTag(tok *token) *tagscope {
+	for ts := tags; ts != nil; ts = ts.next {
+		if reflect.DeepEqual(tok.name, ts.name) {
+			return ts
+		}
+	}
+	return nil
+}
+
+func pushTag(name []rune, ty *typ) {
+	tags = &tagscope{next: tags, name: name, ty: ty}
+}
+
 func findVar(tok *token) *va {
 	for vl := locals; vl != nil; vl = vl.next {
-		v := vl.v
-		if len(v.name) == tok.len && reflect.DeepEqual(tok.str[:tok.len], v.name) {
-			return v
+		if reflect.DeepEqual(tok.name, vl.name) {
+			return vl.v
+		}
+	}
+	for vl := globals; vl != nil; vl = vl.next {
+		if reflect.DeepEqual(tok.name, vl.name) {
+			return vl.v
 		}
 	}
+	return nil
+}
+
+func findGlobal(name []rune) *va {
 	for vl := globals; vl != nil; vl = vl.next {
-		v := vl.v
-		if len(v.name) == tok.len && reflect.DeepEqual(tok.str[:tok.len], v.name) {
-			return v
+		if reflect.DeepEqual(vl.name, name) {
+			return vl.v
 		}
 	}
 	return nil
@@ -132,14 +309,19 @@ func newVar(v *va, tok *token) *node {
 	return &node{kind: ndVar, v: v, tok: tok}
 }
 
+func pushLocalAlias(name []rune, v *va) {
+	locals = &varlist{next: locals, name: name, v: v}
+}
+
 func newLabel() []rune {
 	s := fmt.Sprintf(".L.data.%d", labelcnt)
+	labelcnt++
 	return []rune(s)
 }
 
 func pushVar(name []rune, ty *typ, isLocal bool) *va {
 	v := &va{name: name, ty: ty, isLocal: isLocal}
-	vl := &varlist{v: v}
+	vl := &varlist{name: name, v: v}
 	if isLocal {
 		vl.next = locals
 		locals = vl
@@ -150,22 +332,175 @@ func pushVar(name []rune, ty *typ, isLocal bool) *va {
 	return v
 }
 
+func stmtExpr(tok *token) *node {
+	n := &node{kind: ndStmtExpr, tok: tok}
+	n.body = stmt()
+	cur := n.body
+	for consume([]rune("}")) == nil {
+		cur.next = stmt()
+		cur = cur.next
+	}
+	expect([]rune(")"))
+	if cur.kind != ndExprStmt {
+		errorTok(cur.tok, "stmt expr returning void is not supported")
+	}
+	*cur = *cur.lhs
+	return n
+}
+
+func compoundLiteral() *node {
+	tok := consume([]rune("("))
+	ty := typeName()
+	expect([]rune(")"))
+	if ty.kind == tyVla {
+		errorTok(tok, "variable-sized object may not be initialized")
+	}
+	if !inFunction {
+		v := pushVar(newLabel(), ty, false)
+		v.isStatic = true
+		v.initializer = gvarInitializer(ty)
+		return newVar(v, tok)
+	}
+	v := pushVar([]rune{}, ty, true)
+	n := &node{kind: ndStmtExpr, tok: tok}
+	n.body = lvarInitializer(v, tok)
+	n.body.next = newVar(v, tok)
+	return n
+}
+
+func consumeBuiltin(name string) *token {
+	if tok := consume([]rune("__builtin_" + name)); tok != nil {
+		return tok
+	}
+	return consume([]rune(name))
+}
+
+func vaBuiltin() *node {
+	if tok := consumeBuiltin("va_start"); tok != nil {
+		expect([]rune("("))
+		ap := assign()
+		expect([]rune(","))
+		assign()
+		expect([]rune(")"))
+		if vaArea == nil {
+			errorTok(tok, "va_start used in a function with fixed arguments")
+		}
+		return newBinary(ndAssign, newUnary(ndDeref, ap, tok), newVar(vaArea, tok), tok)
+	}
+	if tok := consumeBuiltin("va_copy"); tok != nil {
+		expect([]rune("("))
+		dst := assign()
+		expect([]rune(","))
+		src := assign()
+		expect([]rune(")"))
+		return newBinary(ndAssign, newUnary(ndDeref, dst, tok), newUnary(ndDeref, src, tok), tok)
+	}
+	if tok := consumeBuiltin("va_end"); tok != nil {
+		expect([]rune("("))
+		assign()
+		expect([]rune(")"))
+		return newNumber(0, tok)
+	}
+	if tok := consumeBuiltin("va_arg"); tok != nil {
+		expect([]rune("("))
+		n := newUnary(ndVaArg, assign(), tok)
+		expect([]rune(","))
+		n.ty = typeName()
+		expect([]rune(")"))
+		if n.ty.kind == tyStruct || n.ty.kind == tyArray {
+			errorTok(tok, "va_arg of an aggregate type is not supported")
+		}
+		return n
+	}
+	return nil
+}
+
+func genericSelection(tok *token) *node {
+	expect([]rune("("))
+	n := newUnary(ndGeneric, assign(), tok)
+	var h genericAssoc
+	cur := &h
+	for consume([]rune(")")) == nil {
+		expect([]rune(","))
+		a := &genericAssoc{}
+		if consume([]rune("default")) == nil {
+			a.ty = typeName()
+		}
+		expect([]rune(":"))
+		a.expr = assign()
+		cur.next = a
+		cur = a
+	}
+	n.assocs = h.next
+	return n
+}
+
+func staticAssert() {
+	tok := consume([]rune("_Static_assert"))
+	expect([]rune("("))
+	v := constExpr()
+	var msg []rune
+	if consume([]rune(",")) != nil {
+		msg = stringLiteral()
+	}
+	expect([]rune(")"))
+	expect([]rune(";"))
+	if v == 0 {
+		errorTok(tok, "static assertion failed: %s", msg)
+	}
+}
+
 func primary() *node {
-	if consume([]rune("(")) != nil {
+	if n := vaBuiltin(); n != nil {
+		return n
+	}
+	if tok := consume([]rune("_Generic")); tok != nil {
+		return genericSelection(tok)
+	}
+	if tok := consume([]rune("__builtin_alloca")); tok != nil {
+		expect([]rune("("))
+		n := newUnary(ndAlloca, assign(), tok)
+		expect([]rune(")"))
+		useAlloca(tok)
+		return n
+	}
+	if isParenTypeName() {
+		return compoundLiteral()
+	}
+	if tok := consume([]rune("(")); tok != nil {
+		if consume([]rune("{")) != nil {
+			return stmtExpr(tok)
+		}
 		n := expr()
 		expect([]rune(")"))
 		return n
 	}
 	if tok := consume([]rune("sizeof")); tok != nil {
+		if isParenTypeName() {
+			expect([]rune("("))
+			ty := typeName()
+			expect([]rune(")"))
+			if ty.kind == tyVla {
+				var h node
+				n := &node{kind: ndStmtExpr, tok: tok}
+				vlaSize(&h, ty, tok).next = newVar(ty.vlaSize, tok)
+				n.body = h.next
+				return n
+			}
+			if ty.isIncomplete {
+				errorTok(tok, "invalid application of sizeof to an incomplete type")
+			}
+			return newNumber(sizeOf(ty), tok)
+		}
 		return newUnary(ndSizeOf, unary(), tok)
 	}
 
 	if tok := consumeIdent(); tok != nil {
-		if consume([]rune("(")) != nil {
-			return &node{kind: ndFunCall, funcname: tok.str[:tok.len], args: funcArgs(), tok: tok}
-		}
 		v := findVar(tok)
 		if v == nil {
+			if consume([]rune("(")) != nil {
+				return &node{kind: ndFunCall, funcname: tok.name, args: funcArgs(), tok: tok}
+			}
 			errorTok(tok, "undefined variable")
 		}
 		return newVar(v, tok)
@@ -173,8 +508,9 @@ func primary() *node {
 	tok := t
 	if tok.kind == tkStr {
 		t = t.next
-		ty := arrayOf(charType(), tok.contLen)
+		ty := arrayOf(tok.ty, tok.contLen)
 		v := pushVar(newLabel(), ty, false)
+		v.isStatic = true
 		v.contents = tok.contents
 		v.contLen = tok.contLen
 		return newVar(v, tok)
@@ -182,6 +518,10 @@ func primary() *node {
 	if tok.kind != tkNum {
 		errorTok(tok, "expected expression")
 	}
+	if tok.ty != nil {
+		t = t.next
+		return &node{kind: ndNum, ty: tok.ty, val: tok.val, fval: tok.fval, tok: tok}
+	}
 	return newNumber(expectNumber(), tok)
 }
 
@@ -199,39 +539,95 @@ func funcArgs() *node {
 	return h
 }
 
+func structRef(lhs *node, tok *token) *node {
+	n := newUnary(ndMember, lhs, tok)
+	n.memberName = expectIdent()
+	return n
+}
+
+func funCall(fn *node) *node {
+	n := &node{kind: ndFunCall, tok: fn.tok, args: funcArgs()}
+	visit(fn)
+	fty := fn.ty
+	if fty.kind == tyPtr {
+		fty = fty.base
+	}
+	if fty.kind == tyFunc && fty.returnTy.kind == tyStruct {
+		n.retBuf = pushVar([]rune{}, fty.returnTy, true)
+	}
+	if fn.kind == ndVar && fn.v.ty.kind == tyFunc {
+		n.funcname = fn.v.name
+		n.v = fn.v
+		return n
+	}
+	n.lhs = fn
+	return n
+}
+
 func postfix() *node {
 	n := primary()
-	for tok := consume([]rune("[")); tok != nil; tok = consume([]rune("[")) {
-		exp := newBinary(ndAdd, n, expr(), tok)
-		expect([]rune("]"))
-		n = newUnary(ndDeref, exp, tok)
+	for {
+		if consume([]rune("(")) != nil {
+			n = funCall(n)
+			continue
+		}
+		if tok := consume([]rune("[")); tok != nil {
+			exp := newBinary(ndAdd, n, expr(), tok)
+			expect([]rune("]"))
+			n = newUnary(ndDeref, exp, tok)
+			continue
+		}
+		if tok := consume([]rune(".")); tok != nil {
+			n = structRef(n, tok)
+			continue
+		}
+		if tok := consume([]rune("->")); tok != nil {
+			n = structRef(newUnary(ndDeref, n, tok), tok)
+			continue
+		}
+		return n
 	}
-	return n
 }
 
 func unary() *node {
 	if consume([]rune("+")) != nil {
-		return unary()
+		return cast()
 	}
 	if tok := consume([]rune("-")); tok != nil {
-		return newBinary(ndSub, newNumber(0, tok), unary(), tok)
+		return newBinary(ndSub, newNumber(0, tok), cast(), tok)
 	}
 	if tok := consume([]rune("&")); tok != nil {
-		return newUnary(ndAddr, unary(), tok)
+		return newUnary(ndAddr, cast(), tok)
 	}
 	if tok := consume([]rune("*")); tok != nil {
-		return newUnary(ndDeref, unary(), tok)
+		return newUnary(ndDeref, cast(), tok)
 	}
 	return postfix()
 }
 
+func cast() *node {
+	if isParenTypeName() {
+		tok := consume([]rune("("))
+		ty := typeName()
+		expect([]rune(")"))
+		if peek([]rune("{")) {
+			t = tok
+			return unary()
+		}
+		n := newUnary(ndCast, cast(), tok)
+		n.ty = ty
+		return n
+	}
+	return unary()
+}
+
 func mul() *node {
-	n := unary()
+	n := cast()
 	for {
 		if tok := consume([]rune("*")); tok != nil {
-			n = newBinary(ndMul, n, unary(), tok)
+			n = newBinary(ndMul, n, cast(), tok)
 		} else if tok := consume([]rune("/")); tok != nil {
-			n = newBinary(ndDiv, n, unary(), tok)
+			n = newBinary(ndDiv, n, cast(), tok)
 		} else {
 			return n
 		}
@@ -281,8 +677,55 @@ func equality() *node {
 	}
 }
 
-func assign() *node {
+func conditional() *node {
 	n := equality()
+	tok := consume([]rune("?"))
+	if tok == nil {
+		return n
+	}
+	c := &node{kind: ndCond, cond: n, tok: tok}
+	c.then = expr()
+	expect([]rune(":"))
+	c.els = conditional()
+	return c
+}
+
+func constExpr() int {
+	n := conditional()
+	visit(n)
+	return eval(n)
+}
+
+func isConstExpr(n *node) bool {
+	switch n.kind {
+	case ndAdd:
+		fallthrough
+	case ndSub:
+		fallthrough
+	case ndMul:
+		fallthrough
+	case ndDiv:
+		fallthrough
+	case ndEq:
+		fallthrough
+	case ndNe:
+		fallthrough
+	case ndLt:
+		fallthrough
+	case ndLe:
+		return isConstExpr(n.lhs) && isConstExpr(n.rhs)
+	case ndCond:
+		return isConstExpr(n.cond) && isConstExpr(n.then) && isConstExpr(n.els)
+	case ndCast:
+		return isConstExpr(n.lhs)
+	case ndNum:
+		return true
+	}
+	return false
+}
+
+func assign() *node {
+	n := conditional()
 	if tok := consume([]rune("=")); tok != nil {
 		n = newBinary(ndAssign, n, assign(), tok)
 	}
@@ -339,14 +782,27 @@ func stmt() *node {
 	if tok := consume([]rune("{")); tok != nil {
 		var h node
 		cur := &h
+		sv := vlaBase
+		vlaBase = nil
 		for consume([]rune("}")) == nil {
 			cur.next = stmt()
 			cur = cur.next
 		}
+		if vlaBase != nil {
+			cur.next = &node{kind: ndVlaFree, v: vlaBase, tok: tok}
+		}
+		vlaBase = sv
 		n := &node{kind: ndBlock, tok: tok}
 		n.body = h.next
 		return n
 	}
+	if tok := t; peek([]rune("_Static_assert")) {
+		staticAssert()
+		return &node{kind: ndNull, tok: tok}
+	}
+	if isAsm() {
+		return asmStatement()
+	}
 	if isTypeName() {
 		return declaration()
 	}
@@ -355,123 +811,1446 @@ func stmt() *node {
 	return n
 }
 
-func isTypeName() bool {
-	return peek([]rune("char")) || peek([]rune("int"))
-}
-
-func readExprStmt() *node {
-	tt := t
-	return newUnary(ndExprStmt, expr(), tt)
+func isAsm() bool {
+	return peek([]rune("asm")) || peek([]rune("__asm__")) || peek([]rune("__asm"))
 }
 
-func declaration() *node {
+// asmStatement parses
+//
+//	asm qualifiers ( template [: outputs [: inputs [: clobbers]]] ) ;
+func asmStatement() *node {
 	tok := t
-	ty := baseType()
-	name := expectIdent()
-	ty = readTypeSuffix(ty)
-	v := pushVar(name, ty, true)
-	if consume([]rune(";")) != nil {
-		return &node{kind: ndNull, tok: tok}
+	t = t.next
+	for consume([]rune("volatile")) != nil || consume([]rune("__volatile__")) != nil {
 	}
-	expect([]rune("="))
-	lhs := &node{kind: ndVar, tok: tok, v: v}
-	rhs := expr()
+	expect([]rune("("))
+	a := &asmStmt{str: stringLiteral()}
+	if consume([]rune(":")) != nil {
+		a.isExtended = true
+		var h asmOperand
+		cur := asmOperands(&h, true)
+		if consume([]rune(":")) != nil {
+			cur = asmOperands(cur, false)
+			if consume([]rune(":")) != nil && !peek([]rune(")")) {
+				for {
+					a.clobbers = append(a.clobbers, stringLiteral())
+					if consume([]rune(",")) == nil {
+						break
+					}
+				}
+			}
+		}
+		a.ops = h.next
+	}
+	expect([]rune(")"))
 	expect([]rune(";"))
-	n := newBinary(ndAssign, lhs, rhs, tok)
-	return newUnary(ndExprStmt, n, tok)
+	return &node{kind: ndAsm, asm: a, tok: tok}
 }
 
-func globalVar() {
-	ty := baseType()
-	name := expectIdent()
-	ty = readTypeSuffix(ty)
-	expect([]rune(";"))
-	pushVar(name, ty, false)
+func asmOperands(cur *asmOperand, isOutput bool) *asmOperand {
+	if peek([]rune(":")) || peek([]rune(")")) {
+		return cur
+	}
+	for {
+		op := &asmOperand{isOutput: isOutput}
+		if consume([]rune("[")) != nil {
+			tok := consumeIdent()
+			if tok == nil {
+				errorTok(t, "expected an identifier")
+			}
+			op.name = tok.name
+			expect([]rune("]"))
+		}
+		tok := t
+		op.constraint = stringLiteral()
+		if isOutput && (len(op.constraint) == 0 || op.constraint[0] != '=' && op.constraint[0] != '+') {
+			errorTok(tok, "output operand constraint lacks '='")
+		}
+		expect([]rune("("))
+		op.expr = expr()
+		expect([]rune(")"))
+		cur.next = op
+		cur = op
+		if consume([]rune(",")) == nil {
+			return cur
+		}
+	}
 }
 
-func function() *fun {
-	locals = nil
-	baseType()
-	fn := &fun{name: expectIdent()}
-	expect([]rune("("))
-	fn.params = readFuncParams()
-	expect([]rune("{"))
-	var h node
-	cur := &h
-	for consume([]rune("}")) == nil {
-		cur.next = stmt()
-		cur = cur.next
+// stringLiteral reads one or more adjacent narrow string literals and
+// returns their concatenated text without the terminating NUL.
+func stringLiteral() []rune {
+	if t.kind != tkStr || t.ty.kind != tyChar {
+		errorTok(t, "expected string literal")
 	}
-	fn.node = h.next
-	fn.locals = locals
-	return fn
+	var b []byte
+	for ; t.kind == tkStr && t.ty.kind == tyChar; t = t.next {
+		for _, c := range t.contents[:t.contLen-1] {
+			b = append(b, byte(c))
+		}
+	}
+	return []rune(string(b))
 }
 
-func baseType() *typ {
-	var ty *typ
-	if consume([]rune("char")) != nil {
-		ty = charType()
-	} else {
-		expect([]rune("int"))
-		ty = intType()
+func isTypeName() bool {
+	return peek([]rune("const")) || peek([]rune("volatile")) || peek([]rune("restrict")) ||
+		peek([]rune("__restrict")) || peek([]rune("__restrict__")) || peek([]rune("char")) || peek([]rune("int")) || peek([]rune("signed")) || peek([]rune("unsigned")) || peek([]rune("float")) || peek([]rune("double")) || peek([]rune("struct")) ||
+		peek([]rune("static")) || peek([]rune("extern")) || peek([]rune("_Alignas")) || peek([]rune("__attribute__")) ||
+		peek([]rune("_Thread_local")) || peek([]rune("__thread")) ||
+		peek([]rune("__builtin_va_list")) || peek([]rune("va_list"))
+}
+
+func isParenTypeName() bool {
+	if !peek([]rune("(")) {
+		return false
 	}
-	for consume([]rune("*")) != nil {
-		ty = pointerTo(ty)
+	tok := t
+	t = t.next
+	f := isTypeName()
+	t = tok
+	return f
+}
+
+func typeName() *typ {
+	var name []rune
+	tok := t
+	ty := declarator(baseType(), &name)
+	if name != nil {
+		errorTok(tok, "unexpected identifier in type name")
 	}
 	return ty
 }
 
-func readTypeSuffix(b *typ) *typ {
-	if consume([]rune("[")) == nil {
-		return b
-	}
-	sz := expectNumber()
-	expect([]rune("]"))
-	b = readTypeSuffix(b)
-	return arrayOf(b, sz)
+func readExprStmt() *node {
+	tt := t
+	return newUnary(ndExprStmt, expr(), tt)
 }
 
-func readFuncParam() *varlist {
-	ty := baseType()
-	name := expectIdent()
-	ty = readTypeSuffix(ty)
-	return &varlist{v: pushVar(name, ty, true)}
+type designator struct {
+	next *designator
+	idx  int
+	mem  *member
 }
 
-func readFuncParams() *varlist {
-	if consume([]rune(")")) != nil {
-		return nil
+type initElem struct {
+	ty       *typ
+	tok      *token
+	expr     *node
+	children []*initElem
+}
+
+func newInitElem(ty *typ, tok *token) *initElem {
+	ie := &initElem{ty: ty, tok: tok}
+	if ty.kind == tyArray {
+		ie.children = make([]*initElem, ty.arraySize)
 	}
-	h := readFuncParam()
-	cur := h
-	for consume([]rune(")")) == nil {
-		expect([]rune(","))
-		cur.next = readFuncParam()
-		cur = cur.next
+	if ty.kind == tyStruct {
+		n := 0
+		for m := ty.members; m != nil; m = m.next {
+			n++
+		}
+		ie.children = make([]*initElem, n)
 	}
-	return h
+	return ie
 }
 
-func isFunction() bool {
+func initChild(ie *initElem, i int, ty *typ) *initElem {
+	for len(ie.children) <= i {
+		ie.children = append(ie.children, nil)
+	}
+	if ie.children[i] == nil {
+		if ty.isIncomplete {
+			// A flexible array member is sized by its own initializer
+			// without completing the member type shared by the struct.
+			c := *ty
+			ty = &c
+		}
+		ie.children[i] = newInitElem(ty, t)
+	}
+	return ie.children[i]
+}
+
+func peekEnd() bool {
 	tok := t
-	baseType()
-	f := (consumeIdent() != nil && consume([]rune("(")) != nil)
+	f := consume([]rune("}")) != nil || (consume([]rune(",")) != nil && consume([]rune("}")) != nil)
 	t = tok
 	return f
 }
 
-func program() *prog {
-	var h fun
-	cur := &h
-	globals = nil
-	for !atEOF() {
-		if isFunction() {
-			cur.next = function()
-			cur = cur.next
-		} else {
-			globalVar()
-		}
+func consumeEnd() bool {
+	tok := t
+	if consume([]rune("}")) != nil || (consume([]rune(",")) != nil && consume([]rune("}")) != nil) {
+		return true
+	}
+	t = tok
+	return false
+}
+
+func peekDesignator() bool {
+	if !peek([]rune(",")) {
+		return false
+	}
+	tok := t
+	t = t.next
+	f := peek([]rune("[")) || peek([]rune("."))
+	t = tok
+	return f
+}
 
+func skipExcessElement() {
+	if consume([]rune("{")) != nil {
+		for !consumeEnd() {
+			skipExcessElement()
+			if !peek([]rune("}")) {
+				expect([]rune(","))
+			}
+		}
+		return
+	}
+	assign()
+}
+
+func arrayDesignator(ty *typ) int {
+	tok := consume([]rune("["))
+	i := constExpr()
+	if i < 0 || (!ty.isIncomplete && i >= ty.arraySize) {
+		errorTok(tok, "array designator index exceeds array bounds")
+	}
+	expect([]rune("]"))
+	return i
+}
+
+func structDesignator(ty *typ) int {
+	expect([]rune("."))
+	tok := t
+	name := expectIdent()
+	i := 0
+	for m := ty.members; m != nil; m = m.next {
+		if reflect.DeepEqual(m.name, name) {
+			return i
+		}
+		i++
+	}
+	errorTok(tok, "struct has no such member")
+	return 0
+}
+
+func memberAt(ty *typ, i int) *member {
+	m := ty.members
+	for ; m != nil && i > 0; i-- {
+		m = m.next
+	}
+	return m
+}
+
+func designation(ie *initElem) {
+	ty := ie.ty
+	if peek([]rune("[")) && ty.kind == tyArray {
+		i := arrayDesignator(ty)
+		designation(initChild(ie, i, ty.base))
+		return
+	}
+	if peek([]rune(".")) && ty.kind == tyStruct {
+		i := structDesignator(ty)
+		designation(initChild(ie, i, memberAt(ty, i).ty))
+		return
+	}
+	expect([]rune("="))
+	initializer2(ie)
+}
+
+func stringInitializer(ie *initElem) {
+	tok := t
+	t = t.next
+	ty := ie.ty
+	if ty.isIncomplete {
+		ty.arraySize = tok.contLen
+		ty.isIncomplete = false
+	}
+	l := ty.arraySize
+	if tok.contLen < l {
+		l = tok.contLen
+	}
+	for i := 0; i < l; i++ {
+		initChild(ie, i, ty.base).expr = newNumber(int(tok.contents[i]), tok)
+	}
+}
+
+func arrayInitializer1(ie *initElem) {
+	ty := ie.ty
+	expect([]rune("{"))
+	i := 0
+	max := 0
+	first := true
+	warned := false
+	for !consumeEnd() {
+		if !first {
+			expect([]rune(","))
+		}
+		first = false
+		if peek([]rune("[")) {
+			i = arrayDesignator(ty)
+			designation(initChild(ie, i, ty.base))
+		} else if ty.isIncomplete || i < ty.arraySize {
+			initializer2(initChild(ie, i, ty.base))
+		} else {
+			if !warned {
+				warnTok(t, "excess elements in initializer")
+				warned = true
+			}
+			skipExcessElement()
+		}
+		i++
+		if max < i {
+			max = i
+		}
+	}
+	if ty.isIncomplete {
+		ty.arraySize = max
+		ty.isIncomplete = false
+	}
+}
+
+func arrayInitializer2(ie *initElem) {
+	ty := ie.ty
+	for i := 0; i < ty.arraySize; i++ {
+		if i > 0 {
+			if peekEnd() || peekDesignator() {
+				return
+			}
+			expect([]rune(","))
+		}
+		initializer2(initChild(ie, i, ty.base))
+	}
+}
+
+func structInitializer1(ie *initElem) {
+	ty := ie.ty
+	expect([]rune("{"))
+	i := 0
+	first := true
+	warned := false
+	for !consumeEnd() {
+		if !first {
+			expect([]rune(","))
+		}
+		first = false
+		if peek([]rune(".")) {
+			i = structDesignator(ty)
+			designation(initChild(ie, i, memberAt(ty, i).ty))
+		} else if m := memberAt(ty, i); m != nil {
+			initializer2(initChild(ie, i, m.ty))
+		} else {
+			if !warned {
+				warnTok(t, "excess elements in initializer")
+				warned = true
+			}
+			skipExcessElement()
+			continue
+		}
+		i++
+	}
+}
+
+func structInitializer2(ie *initElem) {
+	ty := ie.ty
+	i := 0
+	for m := ty.members; m != nil; m = m.next {
+		if i > 0 {
+			if peekEnd() || peekDesignator() {
+				return
+			}
+			expect([]rune(","))
+		}
+		initializer2(initChild(ie, i, m.ty))
+		i++
+	}
+}
+
+func initializer2(ie *initElem) {
+	ty := ie.ty
+	ie.tok = t
+	if ty.kind == tyArray && ty.base.kind == tyChar && t.kind == tkStr && t.ty.kind == tyChar {
+		stringInitializer(ie)
+		return
+	}
+	if ty.kind == tyArray {
+		if peek([]rune("{")) {
+			arrayInitializer1(ie)
+		} else {
+			arrayInitializer2(ie)
+		}
+		return
+	}
+	if ty.kind == tyStruct {
+		if peek([]rune("{")) {
+			structInitializer1(ie)
+			return
+		}
+		tok := t
+		n := assign()
+		visit(n)
+		if n.ty.kind == tyStruct {
+			ie.expr = n
+			return
+		}
+		t = tok
+		structInitializer2(ie)
+		return
+	}
+	open := consume([]rune("{")) != nil
+	ie.expr = assign()
+	if open && !consumeEnd() {
+		errorTok(t, "expected '}'")
+	}
+}
+
+func readInitializer(ty *typ) *initElem {
+	ie := newInitElem(ty, t)
+	initializer2(ie)
+	return ie
+}
+
+func newDesgNode2(v *va, desg *designator, tok *token) *node {
+	if desg == nil {
+		return newVar(v, tok)
+	}
+	n := newDesgNode2(v, desg.next, tok)
+	if desg.mem != nil {
+		m := &node{kind: ndMember, lhs: n, tok: tok}
+		m.memberName = desg.mem.name
+		m.member = desg.mem
+		return m
+	}
+	n = newBinary(ndAdd, n, newNumber(desg.idx, tok), tok)
+	return newUnary(ndDeref, n, tok)
+}
+
+func newDesgNode(v *va, desg *designator, rhs *node) *node {
+	lhs := newDesgNode2(v, desg, rhs.tok)
+	n := newBinary(ndAssign, lhs, rhs, rhs.tok)
+	n.isInit = true
+	return newUnary(ndExprStmt, n, rhs.tok)
+}
+
+func createLvarInit(cur *node, ie *initElem, ty *typ, v *va, desg *designator) *node {
+	if ie != nil && ie.expr != nil {
+		cur.next = newDesgNode(v, desg, ie.expr)
+		return cur.next
+	}
+	if ty.kind == tyArray {
+		for i := 0; i < ty.arraySize; i++ {
+			var c *initElem
+			if ie != nil && i < len(ie.children) {
+				c = ie.children[i]
+			}
+			cur = createLvarInit(cur, c, ty.base, v, &designator{next: desg, idx: i})
+		}
+		return cur
+	}
+	if ty.kind == tyStruct {
+		i := 0
+		for m := ty.members; m != nil; m = m.next {
+			var c *initElem
+			if ie != nil {
+				c = ie.children[i]
+			}
+			if c != nil && m.ty.isIncomplete {
+				errorTok(c.tok, "non-static initialization of a flexible array member")
+			}
+			cur = createLvarInit(cur, c, m.ty, v, &designator{next: desg, mem: m})
+			i++
+		}
+		return cur
+	}
+	cur.next = newDesgNode(v, desg, newNumber(0, t))
+	return cur.next
+}
+
+func lvarInitializer(v *va, tok *token) *node {
+	ie := readInitializer(v.ty)
+	var h node
+	createLvarInit(&h, ie, v.ty, v, nil)
+	n := &node{kind: ndBlock, tok: tok}
+	n.body = h.next
+	return n
+}
+
+func declaration() *node {
+	tok := t
+	attr := readStorageClass()
+	base := baseType()
+	if consume([]rune(";")) != nil {
+		return &node{kind: ndNull, tok: tok}
+	}
+	var h node
+	cur := &h
+	for {
+		cur.next = localDeclarator(base, attr)
+		cur = cur.next
+		if consume([]rune(";")) != nil {
+			break
+		}
+		expect([]rune(","))
+	}
+	if h.next.next == nil {
+		return h.next
+	}
+	return &node{kind: ndBlock, body: h.next, tok: tok}
+}
+
+func localDeclarator(base *typ, attr varAttr) *node {
+	tok := t
+	var name []rune
+	ty := namedDeclarator(base, &name)
+	readAttributes(&attr)
+	if ty.kind == tyVla && attr.sc != scNone {
+		errorTok(tok, "variable length array cannot have static or extern storage")
+	}
+	if attr.isTls && attr.sc == scNone {
+		errorTok(tok, "thread-local variable at block scope must be static or extern")
+	}
+	if attr.sc == scExtern || ty.kind == tyFunc {
+		pushLocalAlias(name, declareGlobal(name, ty, attr, tok))
+		return &node{kind: ndNull, tok: tok}
+	}
+	if attr.sc == scStatic {
+		v := pushVar(newLabel(), ty, false)
+		v.isStatic = true
+		v.isTls = attr.isTls
+		applyAttr(v, attr)
+		pushLocalAlias(name, v)
+		if consume([]rune("=")) != nil {
+			v.initializer = gvarInitializer(ty)
+		} else if ty.isIncomplete {
+			errorTok(tok, "incomplete type")
+		}
+		return &node{kind: ndNull, tok: tok}
+	}
+	if ty.kind == tyVla {
+		v := pushVar(name, ty, true)
+		if peek([]rune("=")) {
+			errorTok(tok, "variable-sized object may not be initialized")
+		}
+		return vlaAlloc(v, tok)
+	}
+	v := pushVar(name, ty, true)
+	v.align = attr.align
+	var h node
+	cur := &h
+	if ty.kind == tyPtr {
+		cur = vlaSize(cur, ty.base, tok)
+	}
+	if consume([]rune("=")) != nil {
+		cur.next = lvarInitializer(v, tok)
+	} else {
+		if ty.isIncomplete {
+			errorTok(tok, "incomplete type")
+		}
+		cur.next = &node{kind: ndNull, tok: tok}
+	}
+	if h.next.next == nil {
+		return h.next
+	}
+	return &node{kind: ndBlock, body: h.next, tok: tok}
+}
+
+func useAlloca(tok *token) *va {
+	if !inFunction {
+		errorTok(tok, "alloca outside of a function")
+	}
+	if allocaBase == nil {
+		allocaBase = pushVar([]rune("__alloca_base__"), intType(), true)
+	}
+	return allocaBase
+}
+
+// vlaSize appends statements computing the size in bytes of each
+// variable-length dimension of ty and returns the last one.
+func vlaSize(cur *node, ty *typ, tok *token) *node {
+	if ty.kind != tyVla {
+		return cur
+	}
+	cur = vlaSize(cur, ty.base, tok)
+	var base *node
+	if ty.base.kind == tyVla {
+		base = newVar(ty.base.vlaSize, tok)
+	} else {
+		base = newNumber(sizeOf(ty.base), tok)
+	}
+	if ty.vlaSize == nil {
+		ty.vlaSize = pushVar([]rune{}, intType(), true)
+	}
+	n := newBinary(ndAssign, newVar(ty.vlaSize, tok), newBinary(ndMul, base, ty.vlaLen, tok), tok)
+	cur.next = newUnary(ndExprStmt, n, tok)
+	return cur.next
+}
+
+// vlaAlloc allocates the storage of v on the stack. The first allocation
+// in a block records the stack base so the block can release it on exit.
+func vlaAlloc(v *va, tok *token) *node {
+	var h node
+	cur := &h
+	if vlaBase == nil {
+		vlaBase = pushVar([]rune{}, intType(), true)
+		n := newBinary(ndAssign, newVar(vlaBase, tok), newVar(useAlloca(tok), tok), tok)
+		cur.next = newUnary(ndExprStmt, n, tok)
+		cur = cur.next
+	}
+	cur = vlaSize(cur, v.ty, tok)
+	ptr := &node{kind: ndVlaPtr, v: v, tok: tok}
+	n := newBinary(ndAssign, ptr, newUnary(ndAlloca, newVar(v.ty.vlaSize, tok), tok), tok)
+	cur.next = newUnary(ndExprStmt, n, tok)
+	return &node{kind: ndBlock, body: h.next, tok: tok}
+}
+
+func newInitVal(cur *initializer, sz int, val int) *initializer {
+	cur.next = &initializer{sz: sz, val: val}
+	return cur.next
+}
+
+func newInitLabel(cur *initializer, label []rune, addend int) *initializer {
+	cur.next = &initializer{sz: 8, label: label, addend: addend}
+	return cur.next
+}
+
+func newInitZero(cur *initializer, nbytes int) *initializer {
+	if nbytes <= 0 {
+		return cur
+	}
+	return newInitVal(cur, nbytes, 0)
+}
+
+func eval(n *node) int {
+	return eval2(n, nil)
+}
+
+func eval2(n *node, label *[]rune) int {
+	if isFlonum(n.ty) {
+		return int(evalDouble(n))
+	}
+	switch n.kind {
+	case ndAdd:
+		if n.ty.base != nil {
+			return eval2(n.lhs, label) + eval(n.rhs)*sizeOf(n.ty.base)
+		}
+		return eval(n.lhs) + eval(n.rhs)
+	case ndSub:
+		if n.ty.base != nil {
+			return eval2(n.lhs, label) - eval(n.rhs)*sizeOf(n.ty.base)
+		}
+		return eval(n.lhs) - eval(n.rhs)
+	case ndMul:
+		return eval(n.lhs) * eval(n.rhs)
+	case ndDiv:
+		d := eval(n.rhs)
+		if d == 0 {
+			errorTok(n.rhs.tok, "division by zero in constant expression")
+		}
+		return eval(n.lhs) / d
+	case ndEq:
+		if isFlonum(n.lhs.ty) {
+			return boolToInt(evalDouble(n.lhs) == evalDouble(n.rhs))
+		}
+		return boolToInt(eval(n.lhs) == eval(n.rhs))
+	case ndNe:
+		if isFlonum(n.lhs.ty) {
+			return boolToInt(evalDouble(n.lhs) != evalDouble(n.rhs))
+		}
+		return boolToInt(eval(n.lhs) != eval(n.rhs))
+	case ndLt:
+		if isFlonum(n.lhs.ty) {
+			return boolToInt(evalDouble(n.lhs) < evalDouble(n.rhs))
+		}
+		return boolToInt(eval(n.lhs) < eval(n.rhs))
+	case ndLe:
+		if isFlonum(n.lhs.ty) {
+			return boolToInt(evalDouble(n.lhs) <= evalDouble(n.rhs))
+		}
+		return boolToInt(eval(n.lhs) <= eval(n.rhs))
+	case ndCond:
+		if evalDouble(n.cond) != 0 {
+			return eval2(n.then, label)
+		}
+		return eval2(n.els, label)
+	case ndCast:
+		if isFlonum(n.lhs.ty) && isUnsigned64(n.ty) {
+			return int(uint64(evalDouble(n.lhs)))
+		}
+		v := eval2(n.lhs, label)
+		if sizeOf(n.ty) == 1 && n.ty.isUnsigned {
+			return int(uint8(v))
+		} else if sizeOf(n.ty) == 1 {
+			return int(int8(v))
+		} else if sizeOf(n.ty) == 2 && n.ty.isUnsigned {
+			return int(uint16(v))
+		} else if sizeOf(n.ty) == 2 {
+			return int(int16(v))
+		} else if sizeOf(n.ty) == 4 && n.ty.isUnsigned {
+			return int(uint32(v))
+		} else if sizeOf(n.ty) == 4 {
+			return int(int32(v))
+		}
+		return v
+	case ndNum:
+		return n.val
+	case ndAddr:
+		return evalAddr(n.lhs, label)
+	case ndVar:
+		if n.ty.kind == tyArray || n.ty.kind == tyFunc {
+			return evalAddr(n, label)
+		}
+	}
+	errorTok(n.tok, "not a constant expression")
+	return 0
+}
+
+func evalDouble(n *node) float64 {
+	if isUnsigned64(n.ty) {
+		return float64(uint64(eval(n)))
+	}
+	if !isFlonum(n.ty) {
+		return float64(eval(n))
+	}
+	switch n.kind {
+	case ndAdd:
+		return evalDouble(n.lhs) + evalDouble(n.rhs)
+	case ndSub:
+		return evalDouble(n.lhs) - evalDouble(n.rhs)
+	case ndMul:
+		return evalDouble(n.lhs) * evalDouble(n.rhs)
+	case ndDiv:
+		return evalDouble(n.lhs) / evalDouble(n.rhs)
+	case ndCond:
+		if evalDouble(n.cond) != 0 {
+			return evalDouble(n.then)
+		}
+		return evalDouble(n.els)
+	case ndCast:
+		fallthrough
+	case ndNum:
+		v := n.fval
+		if n.kind == ndCast {
+			v = evalDouble(n.lhs)
+		}
+		if n.ty.kind == tyFloat {
+			return float64(float32(v))
+		}
+		return v
+	}
+	errorTok(n.tok, "not a constant expression")
+	return 0
+}
+
+func evalAddr(n *node, label *[]rune) int {
+	switch n.kind {
+	case ndVar:
+		if label == nil || *label != nil || n.v.isLocal || n.v.isTls {
+			errorTok(n.tok, "not a constant expression")
+		}
+		*label = n.v.name
+		return 0
+	case ndDeref:
+		return eval2(n.lhs, label)
+	case ndMember:
+		return evalAddr(n.lhs, label) + n.member.offset
+	}
+	errorTok(n.tok, "not a constant expression")
+	return 0
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func writeGvarData(cur *initializer, ie *initElem, ty *typ) *initializer {
+	if ie == nil {
+		return newInitZero(cur, sizeOf(ty))
+	}
+	if ie.expr != nil {
+		n := ie.expr
+		visit(n)
+		if isScalar(ty) && (isFlonum(ty) || isFlonum(n.ty)) {
+			n = newCast(n, ty)
+		}
+		if ty.kind == tyFloat {
+			return newInitVal(cur, 4, int(math.Float32bits(float32(evalDouble(n)))))
+		}
+		if ty.kind == tyDouble {
+			return newInitVal(cur, 8, int(math.Float64bits(evalDouble(n))))
+		}
+		var label []rune
+		addend := eval2(n, &label)
+		if label != nil {
+			return newInitLabel(cur, label, addend)
+		}
+		return newInitVal(cur, sizeOf(ty), addend)
+	}
+	if ty.kind == tyArray {
+		for i := 0; i < ty.arraySize; i++ {
+			var c *initElem
+			if i < len(ie.children) {
+				c = ie.children[i]
+			}
+			cur = writeGvarData(cur, c, ty.base)
+		}
+		return cur
+	}
+	if ty.kind == tyStruct {
+		i := 0
+		end := 0
+		var bits []byte
+		for m := ty.members; m != nil; m = m.next {
+			c := ie.children[i]
+			if m.isBitfield {
+				bits = writeBitfield(bits, c, m, end)
+				i++
+				continue
+			}
+			cur, end = flushBitfields(cur, bits, end)
+			bits = nil
+			mty := m.ty
+			if c != nil && mty.isIncomplete {
+				mty = c.ty
+			}
+			cur = newInitZero(cur, m.offset-end)
+			cur = writeGvarData(cur, c, mty)
+			end = m.offset + sizeOf(mty)
+			i++
+		}
+		cur, end = flushBitfields(cur, bits, end)
+		return newInitZero(cur, sizeOf(ty)-end)
+	}
+	return newInitZero(cur, sizeOf(ty))
+}
+
+// writeBitfield sets the bits of m in buf, which holds the bytes of the
+// struct starting at offset start.
+func writeBitfield(buf []byte, ie *initElem, m *member, start int) []byte {
+	val := 0
+	if ie != nil {
+		visit(ie.expr)
+		val = eval(ie.expr)
+	}
+	pos := m.offset*8 + m.bitOffset - start*8
+	for i := 0; i < m.bitWidth; i++ {
+		idx := (pos + i) / 8
+		for len(buf) <= idx {
+			buf = append(buf, 0)
+		}
+		if val>>uint(i)&1 != 0 {
+			buf[idx] |= 1 << uint((pos+i)%8)
+		}
+	}
+	return buf
+}
+
+func flushBitfields(cur *initializer, buf []byte, end int) (*initializer, int) {
+	for _, b := range buf {
+		cur = newInitVal(cur, 1, int(b))
+	}
+	return cur, end + len(buf)
+}
+
+func gvarInitializer(ty *typ) *initializer {
+	ie := readInitializer(ty)
+	var h initializer
+	writeGvarData(&h, ie, ty)
+	return h.next
+}
+
+func declareGlobal(name []rune, ty *typ, attr varAttr, tok *token) *va {
+	v := findGlobal(name)
+	if v == nil {
+		v = pushVar(name, ty, false)
+		v.isExtern = true
+		v.isTls = attr.isTls
+	} else if v.isTls != attr.isTls {
+		errorTok(tok, "conflicting thread-local declaration of '%s'", name)
+	} else if v.isStatic && attr.sc == scNone && ty.kind != tyFunc {
+		errorTok(tok, "non-static declaration of '%s' follows static declaration", name)
+	} else if v.ty.isIncomplete && !ty.isIncomplete {
+		v.ty = ty
+	}
+	if attr.sc == scStatic {
+		v.isStatic = true
+	}
+	if attr.sc != scExtern {
+		v.isExtern = false
+	}
+	applyAttr(v, attr)
+	return v
+}
+
+// applyAttr merges the attributes of a declaration of v into it.
+func applyAttr(v *va, attr varAttr) {
+	v.align = maxAlign(v.align, attr.align)
+	v.isWeak = v.isWeak || attr.isWeak
+	v.isNoreturn = v.isNoreturn || attr.isNoreturn
+	v.isUsed = v.isUsed || attr.isUsed
+	if attr.isCtor {
+		v.isCtor = true
+		v.ctorPrio = attr.ctorPrio
+	}
+	if attr.isDtor {
+		v.isDtor = true
+		v.dtorPrio = attr.dtorPrio
+	}
+	if attr.section != nil {
+		v.section = attr.section
+	}
+	if attr.alias != nil {
+		v.alias = attr.alias
+	}
+}
+
+func globalVar() {
+	attr := readStorageClass()
+	base := baseType()
+	if consume([]rune(";")) != nil {
+		return
+	}
+	globalDeclarators(base, attr)
+}
+
+func globalDeclarators(base *typ, attr varAttr) {
+	for {
+		globalDeclarator(base, attr)
+		if consume([]rune(";")) != nil {
+			return
+		}
+		expect([]rune(","))
+	}
+}
+
+func globalDeclarator(base *typ, attr varAttr) {
+	tok := t
+	var name []rune
+	ty := namedDeclarator(base, &name)
+	readAttributes(&attr)
+	v := declareGlobal(name, ty, attr, tok)
+	if consume([]rune("=")) != nil {
+		if v.initializer != nil {
+			errorTok(tok, "redefinition of '%s'", name)
+		}
+		v.ty = ty
+		v.isExtern = false
+		v.initializer = gvarInitializer(ty)
+	} else if ty.isIncomplete && !v.isExtern && ty.kind != tyArray {
+		errorTok(tok, "incomplete type")
+	}
+}
+
+func function() *fun {
+	locals = nil
+	attr := readStorageClass()
+	tok := t
+	var name []rune
+	base := baseType()
+	ty := namedDeclarator(base, &name)
+	if attr.isTls {
+		errorTok(tok, "function declared thread-local")
+	}
+	fattr := attr
+	readAttributes(&fattr)
+	v := declareGlobal(name, ty, fattr, tok)
+	if consume([]rune(";")) != nil {
+		return nil
+	}
+	if consume([]rune(",")) != nil {
+		globalDeclarators(base, attr)
+		return nil
+	}
+	fn := &fun{name: name, ty: ty, isStatic: v.isStatic, isVariadic: ty.isVariadic,
+		isWeak: v.isWeak, isNoreturn: v.isNoreturn, isUsed: v.isUsed, section: v.section}
+	if isMemoryClass(ty.returnTy) {
+		fn.retPtr = pushVar([]rune("__ret_ptr__"), pointerTo(ty.returnTy), true)
+	}
+	var ph varlist
+	pc := &ph
+	for pl := ty.params; pl != nil; pl = pl.next {
+		pc.next = &varlist{v: pushVar(pl.name, pl.ty, true)}
+		pc = pc.next
+	}
+	fn.params = ph.next
+	vaArea = nil
+	allocaBase = nil
+	vlaBase = nil
+	if fn.isVariadic {
+		fn.vaArea = pushVar([]rune("__va_area__"), vaElemType(), true)
+		fn.regSaveArea = pushVar([]rune("__reg_save_area__"), arrayOf(charType(), 176), true)
+		vaArea = fn.vaArea
+	}
+	inFunction = true
+	expect([]rune("{"))
+	var h node
+	cur := &h
+	for consume([]rune("}")) == nil {
+		cur.next = stmt()
+		cur = cur.next
+	}
+	fn.node = h.next
+	fn.allocaBase = allocaBase
+	fn.locals = locals
+	inFunction = false
+	return fn
+}
+
+// readStorageClass reads storage-class, thread-local and alignment
+// specifiers in any order.
+func readStorageClass() varAttr {
+	var attr varAttr
+	for {
+		if consume([]rune("static")) != nil {
+			attr.sc = scStatic
+		} else if consume([]rune("extern")) != nil {
+			attr.sc = scExtern
+		} else if consume([]rune("_Thread_local")) != nil || consume([]rune("__thread")) != nil {
+			attr.isTls = true
+		} else if peek([]rune("_Alignas")) || peek([]rune("__attribute__")) {
+			readAttributes(&attr)
+		} else {
+			return attr
+		}
+	}
+}
+
+// readAttributes reads a sequence of _Alignas specifiers and GNU
+// attribute lists into attr.
+func readAttributes(attr *varAttr) {
+	for {
+		if tok := consume([]rune("_Alignas")); tok != nil {
+			expect([]rune("("))
+			if isTypeName() {
+				attr.align = maxAlign(attr.align, alignOf(typeName()))
+			} else {
+				attr.align = maxAlign(attr.align, checkAlign(constExpr(), tok))
+			}
+			expect([]rune(")"))
+		} else if peek([]rune("__attribute__")) {
+			attributes(attr)
+		} else {
+			return
+		}
+	}
+}
+
+// attributes reads one __attribute__((...)) list into attr. Attributes
+// we do not know are skipped with a warning.
+func attributes(attr *varAttr) {
+	expect([]rune("__attribute__"))
+	expect([]rune("("))
+	expect([]rune("("))
+	for !peek([]rune(")")) {
+		tok := t
+		t = t.next
+		name := strings.TrimSuffix(strings.TrimPrefix(string(tok.str[:tok.len]), "__"), "__")
+		switch name {
+		case "aligned":
+			if consume([]rune("(")) != nil {
+				attr.align = maxAlign(attr.align, checkAlign(constExpr(), tok))
+				expect([]rune(")"))
+			} else {
+				attr.align = maxAlign(attr.align, 16)
+			}
+		case "packed":
+			attr.isPacked = true
+		case "section":
+			expect([]rune("("))
+			attr.section = stringLiteral()
+			expect([]rune(")"))
+		case "alias":
+			expect([]rune("("))
+			attr.alias = stringLiteral()
+			expect([]rune(")"))
+		case "weak":
+			attr.isWeak = true
+		case "noreturn":
+			attr.isNoreturn = true
+		case "constructor":
+			attr.isCtor = true
+			attr.ctorPrio = attrPriority()
+		case "destructor":
+			attr.isDtor = true
+			attr.dtorPrio = attrPriority()
+		case "used":
+			attr.isUsed = true
+		case "unused":
+		default:
+			warnTok(tok, "unknown attribute '%s' ignored", tok.str[:tok.len])
+			skipParens()
+		}
+		if consume([]rune(",")) == nil {
+			break
+		}
+	}
+	expect([]rune(")"))
+	expect([]rune(")"))
+}
+
+// attrPriority reads the optional priority of a constructor or
+// destructor attribute. It returns 0 if there is none.
+func attrPriority() int {
+	if consume([]rune("(")) == nil {
+		return 0
+	}
+	tok := t
+	prio := constExpr()
+	if prio < 0 || prio > 65535 {
+		errorTok(tok, "priority out of range")
+	}
+	expect([]rune(")"))
+	return prio
+}
+
+func skipParens() {
+	if consume([]rune("(")) == nil {
+		return
+	}
+	for consume([]rune(")")) == nil {
+		if t.kind == tkEOF {
+			errorTok(t, "expected ')'")
+		}
+		if peek([]rune("(")) {
+			skipParens()
+		} else {
+			t = t.next
+		}
+	}
+}
+
+func checkAlign(align int, tok *token) int {
+	if align <= 0 || align&(align-1) != 0 {
+		errorTok(tok, "requested alignment is not a positive power of 2")
+	}
+	return align
+}
+
+func maxAlign(a, b int) int {
+	if a < b {
+		return b
+	}
+	return a
+}
+
+func readQualifiers() int {
+	q := 0
+	for {
+		if consume([]rune("const")) != nil {
+			q |= qConst
+		} else if consume([]rune("volatile")) != nil {
+			q |= qVolatile
+		} else if consume([]rune("restrict")) != nil || consume([]rune("__restrict")) != nil || consume([]rune("__restrict__")) != nil {
+			q |= qRestrict
+		} else {
+			return q
+		}
+	}
+}
+
+func baseType() *typ {
+	q := readQualifiers()
+	ty := baseType2()
+	return qualify(ty, q|readQualifiers())
+}
+
+func baseType2() *typ {
+	var ty *typ
+	if peek([]rune("unsigned")) || peek([]rune("signed")) {
+		isUnsigned := consume([]rune("unsigned")) != nil
+		if !isUnsigned {
+			expect([]rune("signed"))
+		}
+		if consume([]rune("char")) != nil {
+			ty = charType()
+		} else {
+			consume([]rune("int"))
+			ty = intType()
+		}
+		ty.isUnsigned = isUnsigned
+	} else if consume([]rune("char")) != nil {
+		ty = charType()
+	} else if consume([]rune("float")) != nil {
+		ty = floatType()
+	} else if consume([]rune("double")) != nil {
+		ty = doubleType()
+	} else if peek([]rune("struct")) {
+		ty = structDecl()
+	} else if consume([]rune("__builtin_va_list")) != nil || consume([]rune("va_list")) != nil {
+		ty = vaListType()
+	} else {
+		expect([]rune("int"))
+		ty = intType()
+	}
+	return ty
+}
+
+func declarator(ty *typ, name *[]rune) *typ {
+	for consume([]rune("*")) != nil {
+		ty = qualify(pointerTo(ty), readQualifiers())
+	}
+	if peek([]rune("(")) && !isParenTypeName() && !isParenEnd() {
+		// The suffix after the parentheses applies first, so skip
+		// the inner declarator, read the suffix and come back.
+		start := t
+		skipParens()
+		ty = readTypeSuffix(ty)
+		end := t
+		t = start.next
+		ty = declarator(ty, name)
+		expect([]rune(")"))
+		t = end
+		return ty
+	}
+	if tok := consumeIdent(); tok != nil {
+		*name = tok.name
+	}
+	return readTypeSuffix(ty)
+}
+
+func isParenEnd() bool {
+	tok := t
+	f := consume([]rune("(")) != nil && consume([]rune(")")) != nil
+	t = tok
+	return f
+}
+
+func namedDeclarator(ty *typ, name *[]rune) *typ {
+	tok := t
+	ty = declarator(ty, name)
+	if *name == nil {
+		errorTok(tok, "expected an identifier")
+	}
+	return ty
+}
+
+func structMember() *member {
+	var name []rune
+	tok := t
+	var attr varAttr
+	readAttributes(&attr)
+	ty := declarator(baseType(), &name)
+	if ty.kind == tyVla {
+		errorTok(tok, "variable length array in struct")
+	}
+	readAttributes(&attr)
+	m := &member{ty: ty, name: name, align: attr.align, isPacked: attr.isPacked}
+	if tok := consume([]rune(":")); tok != nil {
+		if ty.kind != tyInt && ty.kind != tyChar {
+			errorTok(tok, "bit-field has non-integral type")
+		}
+		m.isBitfield = true
+		m.bitWidth = constExpr()
+		if m.bitWidth < 0 || m.bitWidth > sizeOf(ty)*8 {
+			errorTok(tok, "invalid bit-field width")
+		}
+		if m.bitWidth == 0 && name != nil {
+			errorTok(tok, "zero-width bit-field with a name")
+		}
+	} else if name == nil {
+		errorTok(tok, "expected an identifier")
+	}
+	expect([]rune(";"))
+	return m
+}
+
+func structDecl() *typ {
+	expect([]rune("struct"))
+	var attr varAttr
+	readAttributes(&attr)
+	tag := consumeIdent()
+	if tag != nil && !peek([]rune("{")) {
+		ts := findTag(tag)
+		if ts == nil {
+			errorTok(tag, "unknown struct type")
+		}
+		return ts.ty
+	}
+	expect([]rune("{"))
+	ty := &typ{kind: tyStruct, align: 1}
+	if tag != nil {
+		pushTag(tag.name, ty)
+	}
+	var h member
+	cur := &h
+	for consume([]rune("}")) == nil {
+		if peek([]rune("_Static_assert")) {
+			staticAssert()
+			continue
+		}
+		if cur != &h && cur.ty.isIncomplete {
+			errorTok(t, "flexible array member must be the last member")
+		}
+		cur.next = structMember()
+		cur = cur.next
+	}
+	readAttributes(&attr)
+	for m := h.next; m != nil; m = m.next {
+		m.isPacked = m.isPacked || attr.isPacked
+	}
+	bits := 0
+	cur = &h
+	for m := h.next; m != nil; m = m.next {
+		if m.isBitfield {
+			// A bit-field is placed in the next bits unless that makes it
+			// straddle a storage unit of its declared type.
+			sz := sizeOf(m.ty) * 8
+			if m.bitWidth == 0 || bits/sz != (bits+m.bitWidth-1)/sz {
+				bits = alignTo(bits, sz)
+			}
+			m.offset = bits / sz * sz / 8
+			m.bitOffset = bits % sz
+			bits += m.bitWidth
+			if m.name == nil {
+				continue
+			}
+		} else {
+			bits = alignTo(bits, memberAlign(m)*8)
+			m.offset = bits / 8
+			bits += sizeOf(m.ty) * 8
+		}
+		ty.align = maxAlign(ty.align, memberAlign(m))
+		cur.next = m
+		cur = m
+	}
+	cur.next = nil
+	ty.members = h.next
+	ty.align = maxAlign(ty.align, attr.align)
+	ty.size = alignTo(alignTo(bits, 8)/8, ty.align)
+	return ty
+}
+
+func readFuncParams(ret *typ) *typ {
+	ty := funcType(ret)
+	if consume([]rune(")")) != nil {
+		return ty
+	}
+	if tok := t; consume([]rune("void")) != nil {
+		if consume([]rune(")")) != nil {
+			return ty
+		}
+		t = tok
+	}
+	var h paramlist
+	cur := &h
+	for {
+		if consume([]rune("...")) != nil {
+			ty.isVariadic = true
+			expect([]rune(")"))
+			break
+		}
+		var name []rune
+		pty := declarator(baseType(), &name)
+		readAttributes(&varAttr{})
+		if pty.kind == tyArray {
+			pty = pointerTo(pty.base)
+		} else if pty.kind == tyFunc {
+			pty = pointerTo(pty)
+		}
+		if name == nil {
+			name = []rune{}
+		}
+		cur.next = &paramlist{ty: pty, name: name}
+		cur = cur.next
+		if consume([]rune(")")) != nil {
+			break
+		}
+		expect([]rune(","))
+	}
+	ty.params = h.next
+	return ty
+}
+
+func readTypeSuffix(b *typ) *typ {
+	if consume([]rune("(")) != nil {
+		return readFuncParams(b)
+	}
+	if consume([]rune("[")) == nil {
+		return b
+	}
+	sz := 0
+	isIncomplete := true
+	var vlaLen *node
+	if consume([]rune("]")) == nil {
+		if inFunction {
+			n := conditional()
+			visit(n)
+			if isConstExpr(n) {
+				sz = eval(n)
+			} else {
+				vlaLen = n
+			}
+		} else {
+			sz = constExpr()
+		}
+		isIncomplete = false
+		expect([]rune("]"))
+	}
+	tok := t
+	b = readTypeSuffix(b)
+	if b.isIncomplete {
+		errorTok(tok, "incomplete element type")
+	}
+	if vlaLen != nil || b.kind == tyVla {
+		if isIncomplete {
+			errorTok(tok, "incomplete array of variable length arrays")
+		}
+		if vlaLen == nil {
+			vlaLen = newNumber(sz, tok)
+		}
+		return &typ{kind: tyVla, base: b, vlaLen: vlaLen}
+	}
+	b = arrayOf(b, sz)
+	b.isIncomplete = isIncomplete
+	return b
+}
+
+func isFunction() bool {
+	tok := t
+	readStorageClass()
+	var name []rune
+	ty := declarator(baseType(), &name)
+	t = tok
+	return ty.kind == tyFunc
+}
+
+func program() *prog {
+	var h fun
+	cur := &h
+	var ah node
+	acur := &ah
+	globals = nil
+	for !atEOF() {
+		if peek([]rune("_Static_assert")) {
+			staticAssert()
+		} else if isAsm() {
+			n := asmStatement()
+			if n.asm.isExtended {
+				errorTok(n.tok, "extended asm outside of a function")
+			}
+			acur.next = n
+			acur = n
+		} else if isFunction() {
+			fn := function()
+			if fn != nil {
+				cur.next = fn
+				cur = cur.next
+			}
+		} else {
+			globalVar()
+		}
+
+	}
+	for vl := globals; vl != nil; vl = vl.next {
+		// A tentative definition of an array of unknown size that is
+		// never completed defines an array of one element.
+		if v := vl.v; v.ty.isIncomplete && !v.isExtern {
+			v.ty.arraySize = 1
+			v.ty.isIncomplete = false
+		}
 	}
-	return &prog{globals: globals, fns: h.next}
+	return &prog{globals: globals, fns: h.next, asms: ah.next}
 }
@@ -8,6 +8,60 @@ int sub(int x, int y) { return x-y; }
 int add6(int a, int b, int c, int d, int e, int f) {
   return a+b+c+d+e+f;
 }
+int add10(int a, int b, int c, int d, int e, int f, int g, int h, int i, int j) {
+  return a-b+c-d+e-f+g-h+i-j*2;
+}
+int aligned7(int a, int b, int c, int d, int e, int f, int g) {
+  return ((long)__builtin_frame_address(0) & 15) ? 99 : g;
+}
+typedef struct { long a; long b; } S2;
+typedef struct { long a; long b; long c; } S3;
+typedef struct { char a; char b; char c; } C3;
+typedef struct { long a; char b[4]; } S12;
+long sum_s2(S2 s) { return s.a + s.b; }
+long sum_s3(S3 s) { return s.a + s.b + s.c; }
+long sum_c3(C3 s) { return s.a + s.b + s.c; }
+long sum_s12(S12 s) { return s.a + s.b[0] + s.b[3]; }
+long sum_s2_7(long a, long b, long c, long d, long e, S2 s, long f) { return a+b+c+d+e+f + s.a*10 + s.b*20; }
+S2 make_s2(long a, long b) { S2 s = {a, b}; return s; }
+S3 make_s3(long a, long b, long c) { S3 s = {a, b, c}; return s; }
+C3 make_c3(char a, char b, char c) { C3 s = {a, b, c}; return s; }
+long call_s2(S2 (*f)(S2, long)) { S2 s = {3, 4}; S2 r = f(s, 10); return r.a * r.b; }
+long call_s3(S3 (*f)(S3)) { S3 s = {1, 2, 3}; S3 r = f(s); return r.a + r.b + r.c; }
+double add_double(double x, double y) { return x + y; }
+float add_float(float x, float y) { return x + y; }
+long mixed(long a, double b, long c, float d) { return a + (long)(b*10) + c + (long)(d*10); }
+double sum10d(double a, double b, double c, double d, double e, double f, double g, double h, double i, double j) {
+  return a+b+c+d+e+f+g+h+i+j*2;
+}
+typedef struct { double x; double y; } D2;
+typedef struct { float x; float y; float z; } F3;
+typedef struct { double d; long l; } DL;
+double sum_d2(D2 s) { return s.x + s.y; }
+float sum_f3(F3 s) { return s.x + s.y + s.z; }
+double sum_dl(DL s) { return s.d*2 + s.l; }
+D2 make_d2(double x, double y) { D2 s = {x, y}; return s; }
+F3 make_f3(float x, float y, float z) { F3 s = {x, y, z}; return s; }
+DL make_dl(double d, long l) { DL s = {d, l}; return s; }
+double call_d2(D2 (*f)(D2, double)) { D2 s = {1, 2}; D2 r = f(s, 0.5); return r.x*10 + r.y; }
+typedef struct { unsigned long a:3; long b:5; unsigned long :0; unsigned char c:4; long d:60; } BF;
+long bf_size() { return sizeof(BF); }
+void bf_fill(BF *p) { p->a = 5; p->b = -3; p->c = 9; p->d = -12345; }
+long bf_check(BF *p) { return p->a == 6 && p->b == -2 && p->c == 15 && p->d == 77; }
+long bf_size2() { struct { char c; long :4; } s; return sizeof(s); }
+long ext1 = 5;
+long *ext2 = &ext1;
+#include <pthread.h>
+__thread long tls1 = 7;
+long get_tls1() { return tls1; }
+static void *tls_start(void *f) { return (void *)((long (*)(void))f)(); }
+long run_thread(long (*f)(void)) {
+  pthread_t t;
+  void *r;
+  pthread_create(&t, 0, tls_start, (void *)f);
+  pthread_join(t, &r);
+  return (long)r;
+}
 EOF
 
 assert() {
@@ -27,6 +81,54 @@ assert() {
   fi
 }
 
+assert_error() {
+  expected="$1"
+  input="$2"
+
+  if ./chibicc <(echo "$input") > tmp.s 2> tmp.err; then
+    echo "$input => error expected, but it compiled"
+    exit 1
+  fi
+  if grep -qxF -- "$expected" tmp.err; then
+    echo "$input => $expected"
+  else
+    echo "$input => '$expected' expected, but got:"
+    cat tmp.err
+    exit 1
+  fi
+}
+
+assert_warn() {
+  expected="$1"
+  input="$2"
+
+  if ! ./chibicc <(echo "$input") > tmp.s 2> tmp.err; then
+    echo "$input => warning expected, but it failed:"
+    cat tmp.err
+    exit 1
+  fi
+  if [ "$(grep -cxF -- "warning: $expected" tmp.err)" = 1 ]; then
+    echo "$input => warning: $expected"
+  else
+    echo "$input => 'warning: $expected' expected once, but got:"
+    cat tmp.err
+    exit 1
+  fi
+}
+
+assert_asm() {
+  expected="$1"
+  input="$2"
+
+  ./chibicc <(echo "$input") > tmp.s
+  if grep -qF -- "$expected" tmp.s; then
+    echo "$input => $expected"
+  else
+    echo "$input => '$expected' expected in the output"
+    exit 1
+  fi
+}
+
 assert 0 "int main() { return 0;}"
 assert 42 "int main() { return 42;}"
 assert 41 "int main() { return 12 + 34 - 5 ;}"
@@ -150,4 +252,461 @@ assert 108 "int main() { return \"\l\"[0]; }"
 assert 2 "int main() { /* return 1; */ return 2; }"
 assert 2 "int main() { // return 1;
 return 2; }"
+assert 0 "int main() { return ({ 0; }); }"
+assert 2 "int main() { return ({ 0; 1; 2; }); }"
+assert 1 "int main() { ({ 0; return 1; 2; }); return 3; }"
+assert 3 "int main() { return ({ int x=3; x; }); }"
+assert 6 "int main() { return ({ int x=1; int y=2; x+y; }) + 3; }"
+assert 1 "int main() { int x[3]={1,2,3}; return x[0]; }"
+assert 2 "int main() { int x[3]={1,2,3}; return x[1]; }"
+assert 3 "int main() { int x[3]={1,2,3}; return x[2]; }"
+assert 2 "int main() { int x[2][3]={{1,2,3},{4,5,6}}; return x[0][1]; }"
+assert 4 "int main() { int x[2][3]={{1,2,3},{4,5,6}}; return x[1][0]; }"
+assert 6 "int main() { int x[2][3]={{1,2,3},{4,5,6}}; return x[1][2]; }"
+assert 0 "int main() { int x[3]={}; return x[0]; }"
+assert 0 "int main() { int x[3]={}; return x[2]; }"
+assert 2 "int main() { int x[2][3]={{1,2}}; return x[0][1]; }"
+assert 0 "int main() { int x[2][3]={{1,2}}; return x[1][0]; }"
+assert 0 "int main() { int x[2][3]={{1,2}}; return x[1][2]; }"
+assert 3 "int main() { int x[2]={1,2,3}; return x[1]+1; }"
+assert 97 "int main() { char x[4]=\"abc\"; return x[0]; }"
+assert 99 "int main() { char x[4]=\"abc\"; return x[2]; }"
+assert 0 "int main() { char x[4]=\"abc\"; return x[3]; }"
+assert 98 "int main() { char x[2][4]={\"abc\",\"def\"}; return x[0][1]; }"
+assert 102 "int main() { char x[2][4]={\"abc\",\"def\"}; return x[1][2]; }"
+assert 3 "int main() { int x[]={1,2,3}; return x[2]; }"
+assert 24 "int main() { int x[]={1,2,3}; return sizeof(x); }"
+assert 4 "int main() { char x[]=\"foo\"; return sizeof(x); }"
+assert 32 "int main() { int x[][2]={{1,2},{3,4}}; return sizeof(x); }"
+assert 3 "int g=3; int main() { return g; }"
+assert 2 "int g[3]={1,2,3}; int main() { return g[1]; }"
+assert 0 "int g[3]={1}; int main() { return g[2]; }"
+assert 5 "int g[2][3]={{1,2,3},{4,5,6}}; int main() { return g[1][1]; }"
+assert 98 "char g[]=\"abc\"; int main() { return g[1]; }"
+assert 4 "char g[]=\"abc\"; int main() { return sizeof(g); }"
+assert 99 "char *g=\"abc\"; int main() { return g[2]; }"
+assert 102 "char *g[]={\"abc\",\"def\"}; int main() { return g[1][2]; }"
+assert 3 "int x=3; int *g=&x; int main() { return *g; }"
+assert 4 "int a[4]={1,2,3,4}; int *g=&a[3]; int main() { return *g; }"
+assert 3 "int a[4]={1,2,3,4}; int *g=a+2; int main() { return *g; }"
+assert 2 "int a[4]={1,2,3,4}; int *g=&a[3]-2; int main() { return *g; }"
+assert 7 "int g=3+4*2-(8/2); int main() { return g; }"
+assert 1 "int g=2<3; int main() { return g; }"
+assert 2 "int main() { return 1 ? 2 : 3; }"
+assert 3 "int main() { return 0 ? 1 : 3; }"
+assert 5 "int main() { int x=0; return x ? 1 : x+1 ? 5 : 6; }"
+assert 1 "int main() { return (char)257; }"
+assert 44 "int main() { int x=300; return (char)x; }"
+assert 8 "int main() { return sizeof(int); }"
+assert 1 "int main() { return sizeof(char); }"
+assert 8 "int main() { return sizeof(char *); }"
+assert 24 "int main() { return sizeof(int[3]); }"
+assert 48 "int main() { int x[2*3]; return sizeof(x); }"
+assert 32 "int main() { char x[sizeof(int)*4]; return sizeof(x); }"
+assert 16 "int main() { int x[1<2 ? 2 : 3]; return sizeof(x); }"
+assert 2 "int main() { char x[(char)258]; return sizeof(x); }"
+assert 16 "int g[(3+5)/4]; int main() { return sizeof(g); }"
+assert 3 "int g=1 ? 3 : 4; int main() { return g; }"
+assert 3 "int a[4]={1,2,3,4}; int *g=0 ? a : a+2; int main() { return *g; }"
+assert 1 "int main() { struct {int a; int b;} x; x.a=1; x.b=2; return x.a; }"
+assert 2 "int main() { struct {int a; int b;} x; x.a=1; x.b=2; return x.b; }"
+assert 1 "int main() { struct {char a; int b; char c;} x; x.a=1; x.b=2; x.c=3; return x.a; }"
+assert 3 "int main() { struct {char a; int b; char c;} x; x.b=1; x.b=2; x.c=3; return x.c; }"
+assert 24 "int main() { struct {char a; int b; char c;} x; return sizeof(x); }"
+assert 2 "int main() { struct {char a; char b;} x; return sizeof(x); }"
+assert 48 "int main() { struct {int a; int b;} x[3]; return sizeof(x); }"
+assert 6 "int main() { struct {int a[3];} x; x.a[2]=6; return x.a[2]; }"
+assert 7 "int main() { struct {struct {int b;} a;} x; x.a.b=7; return x.a.b; }"
+assert 3 "int main() { struct t {int a; int b;} x; struct t y; y.b=3; return y.b; }"
+assert 5 "int main() { struct t {int a; int b;} x; struct t *p=&x; p->b=5; return x.b; }"
+assert 4 "int main() { struct t {int a; struct t *next;} x; struct t y; x.next=&y; y.a=4; return x.next->a; }"
+assert 3 "int main() { struct t {int a; int b;} x; struct t y; x.a=3; y=x; return y.a; }"
+assert 16 "struct t {int a; int b;}; int main() { struct t x; return sizeof(x); }"
+assert 2 "struct {int a; int b;} g; int main() { g.b=2; return g.b; }"
+assert 1 "int main() { struct {int a; int b;} x={1,2}; return x.a; }"
+assert 2 "int main() { struct {int a; int b;} x={1,2}; return x.b; }"
+assert 0 "int main() { struct {int a; int b;} x={1}; return x.b; }"
+assert 4 "int main() { struct {int a; int b;} x[2]={{1,2},{3,4}}; return x[1].b; }"
+assert 3 "int main() { struct {int a; int b;} x[2]={1,2,3,4}; return x[1].a; }"
+assert 2 "int main() { struct {int a; int b;} x={.b=2,.a=1}; return x.b; }"
+assert 0 "int main() { struct {int a; int b; int c;} x={.b=2}; return x.a+x.c; }"
+assert 3 "int main() { struct {int a; int b; int c;} x={.b=2,3}; return x.c; }"
+assert 5 "int main() { int x[4]={[2]=5}; return x[2]; }"
+assert 0 "int main() { int x[4]={[2]=5}; return x[3]+x[0]; }"
+assert 7 "int main() { int x[4]={[2]=5,7}; return x[3]; }"
+assert 1 "int main() { int x[4]={[3]=4,[0]=1}; return x[0]; }"
+assert 48 "int main() { int x[]={[5]=1}; return sizeof(x); }"
+assert 6 "int main() { int x[2][3]={[1][2]=6}; return x[1][2]; }"
+assert 9 "int main() { struct {int a; int b[2];} x={.b[1]=9}; return x.b[1]; }"
+assert 8 "int main() { struct {int a; int b;} x[2]={[1].b=8}; return x[1].b; }"
+assert 3 "int main() { struct t {int a; int b;} x={1,2}; struct t y=x; return y.a+y.b; }"
+assert 1 "struct {int a; int b;} g={1,2}; int main() { return g.a; }"
+assert 2 "struct {char a; int b;} g={1,2}; int main() { return g.b; }"
+assert 6 "struct {int a; int b;} g[2]={[1]={.b=6}}; int main() { return g[1].b; }"
+assert 0 "struct {int a; int b;} g[2]={[1]={.b=6}}; int main() { return g[0].a+g[1].a; }"
+assert 5 "int g[4]={[1]=5}; int main() { return g[1]; }"
+assert 2 "struct {int a; int b;} g; int *p=&g.b; int main() { g.b=2; return *p; }"
+assert 2 "int main() { return (int[]){1,2,3}[1]; }"
+assert 24 "int main() { return sizeof((int[]){1,2,3}); }"
+assert 3 "int main() { struct t {int a; int b;}; return (struct t){1,2}.a + (struct t){1,2}.b; }"
+assert 2 "int main() { struct t {int a; int b;} x; x=(struct t){.b=2}; return x.b; }"
+assert 7 "int main() { int *p=(int[]){5,6,7}; return p[2]; }"
+assert 6 "int *g=(int[]){4,5,6}; int main() { return g[2]; }"
+assert 3 "static int g=3; int main() { return g; }"
+assert 4 "static int f() { return 4; } int main() { return f(); }"
+assert 5 "extern int ext1; int main() { return ext1; }"
+assert 5 "int main() { extern int ext1; return ext1; }"
+assert 7 "extern int ext1; extern int *ext2; int main() { *ext2=7; return ext1; }"
+assert 3 "int g; int g; int g=3; int main() { return g; }"
+assert 3 "extern int g; int main() { return g; } int g=3;"
+assert 0 "extern int g; int g; int main() { return g; }"
+assert 3 "int f(); int main() { return f(); } int f() { return 3; }"
+assert 7 "int add(int, int); int main() { return add(3, 4); }"
+assert 3 "int f() { static int x; x=x+1; return x; } int main() { f(); f(); return f(); }"
+assert 12 "int f() { static int x=10; x=x+1; return x; } int main() { f(); return f(); }"
+assert 2 "int f() { static int x=1; return x; } int g() { static int x=2; return x; } int main() { return f()*g(); }"
+assert 1 "int main() { static int x[3]={1,2,3}; return x[0]; }"
+assert 5 "int x=5; int main() { static int x=6; return ({ extern int x; x; }); }"
+assert 5 "int main() { return add10(1,2,3,4,5,6,7,8,9,10)+20; }"
+assert 7 "int main() { return aligned7(1,2,3,4,5,6,7); }"
+assert 8 "int main() { return 1+aligned7(1,2,3,4,5,6,7); }"
+assert 7 "int main() { int x=1; return ({ int y=2; aligned7(x,y,3,4,5,6,7); }); }"
+assert 36 "int main() { return sum8(1,2,3,4,5,6,7,8); } int sum8(int a, int b, int c, int d, int e, int f, int g, int h) { return a+b+c+d+e+f+g+h; }"
+assert 3 "int main() { return sub9(20,1,1,1,1,1,1,1,10); } int sub9(int a, int b, int c, int d, int e, int f, int g, int h, int i) { return a-b-c-d-e-f-g-h-i; }"
+assert 3 "int main() { return last8(1,2,3,4,5,6,7,3); } int last8(char a, char b, char c, char d, char e, char f, char g, char h) { return h; }"
+assert 15 "int sum(int n, ...) { __builtin_va_list ap; __builtin_va_start(ap, n); int s=0; int i; for (i=0; i<n; i=i+1) s=s+__builtin_va_arg(ap, int); __builtin_va_end(ap); return s; } int main() { return sum(5, 1, 2, 3, 4, 5); }"
+assert 55 "#include <stdarg.h>
+int sum(int n, ...) { va_list ap; va_start(ap, n); int s=0; int i; for (i=0; i<n; i=i+1) s=s+va_arg(ap, int); va_end(ap); return s; } int main() { return sum(10, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10); }"
+assert 36 "#include <stdarg.h>
+int sum2(int a, int b, int c, int d, int e, int f, int g, ...) { va_list ap; va_start(ap, g); int s=a+b+c+d+e+f+g; s=s+va_arg(ap, int); return s; } int main() { return sum2(1, 2, 3, 4, 5, 6, 7, 8); }"
+assert 99 "#include <stdarg.h>
+int nth(int n, ...) { va_list ap; va_start(ap, n); char *s; int i; for (i=0; i<=n; i=i+1) s=va_arg(ap, char *); return s[1]; } int main() { return nth(1, \"ab\", \"bc\", \"cd\"); }"
+assert 7 "#include <stdarg.h>
+int first(int n, ...) { va_list ap; va_list aq; va_start(ap, n); va_copy(aq, ap); va_arg(ap, int); return va_arg(aq, int); } int main() { return first(1, 7, 8); }"
+assert 8 "#include <stdarg.h>
+int next(va_list ap) { return va_arg(ap, int); } int second(int n, ...) { va_list ap; va_start(ap, n); next(ap); return next(ap); } int main() { return second(1, 7, 8); }"
+assert 52 "#include <stdarg.h>
+int fmt(char *buf, char *f, ...) { va_list ap; va_start(ap, f); vsprintf(buf, f, ap); va_end(ap); return 0; } int main() { char buf[16]; fmt(buf, \"%d-%d\", 12, 345); return buf[4]; }"
+assert 3 "int va_start; int main() { va_start=3; return va_start; }"
+assert 3 "int ret3(); int main() { int (*fp)()=ret3; return fp(); }"
+assert 8 "int add(int x, int y); int main() { int (*fp)(int, int)=&add; return fp(3, 5); }"
+assert 2 "int sub(int, int); int main() { int (*fp)(int, int)=sub; return (*fp)(5, 3); }"
+assert 5 "int twice(int x) { return x*2; } int apply(int (*f)(int), int x) { return f(x)+1; } int main() { return apply(twice, 2); }"
+assert 6 "int twice(int x) { return x*2; } int apply(int f(int), int x) { return f(x); } int main() { return apply(twice, 3); }"
+assert 7 "int inc(int x) { return x+1; } int dec(int x) { return x-1; } int main() { int (*fns[2])(int)={inc, dec}; return fns[0](6)+fns[1](1); }"
+assert 9 "int inc(int x) { return x+1; } int dec(int x) { return x-1; } int (*table[2])(int)={inc, dec}; int main() { return table[0](8); }"
+assert 4 "int inc(int x) { return x+1; } struct ops { int (*op)(int); }; int main() { struct ops o; o.op=inc; return o.op(3); }"
+assert 11 "int inc(int x) { return x+1; } int (*pick())(int) { return inc; } int main() { return pick()(10); }"
+assert 21 "int add6(); int main() { int (*fp)(int,int,int,int,int,int)=add6; return fp(1,2,3,4,5,6); }"
+assert 55 "int add10(); int main() { int (*f)(int,int,int,int,int,int,int,int,int,int)=add10; return f(1,2,3,4,5,6,7,8,9,10)+70; }"
+assert 98 "char *str() { return \"abc\"; } int main() { return *(str()+1); }"
+assert 1 "int f(int x) { return x; } int main() { return sizeof(f); }"
+assert 5 "static int five() { return 5; } int (*g)()=&five; int main() { return g(); }"
+assert 7 "struct s2 {int a; int b;}; int sum_s2(struct s2 s); int main() { struct s2 x={3,4}; return sum_s2(x); }"
+assert 6 "struct s3 {int a; int b; int c;}; int sum_s3(struct s3 s); int main() { struct s3 x={1,2,3}; return sum_s3(x); }"
+assert 6 "struct c3 {char a; char b; char c;}; int sum_c3(struct c3 s); int main() { struct c3 x={1,2,3}; return sum_c3(x); }"
+assert 10 "struct s12 {int a; char b[4];}; int sum_s12(struct s12 s); int main() { struct s12 x={1,{2,0,0,7}}; return sum_s12(x); }"
+assert 125 "struct s2 {int a; int b;}; int sum_s2_7(int a, int b, int c, int d, int e, struct s2 s, int f); int main() { struct s2 x={2,3}; return sum_s2_7(1,2,3,4,5,x,30); }"
+assert 9 "struct s2 {int a; int b;}; struct s2 make_s2(int a, int b); int main() { struct s2 x=make_s2(4,5); return x.b+x.a; }"
+assert 5 "struct s2 {int a; int b;}; struct s2 make_s2(int a, int b); int main() { return make_s2(4,5).b; }"
+assert 8 "struct s3 {int a; int b; int c;}; struct s3 make_s3(int a, int b, int c); int main() { struct s3 x=make_s3(6,7,8); return x.c; }"
+assert 3 "struct c3 {char a; char b; char c;}; struct c3 make_c3(char a, char b, char c); int main() { return make_c3(1,2,3).c; }"
+assert 7 "struct s2 {int a; int b;}; int f(struct s2 s) { return s.a+s.b; } int main() { struct s2 x={3,4}; return f(x); }"
+assert 6 "struct s3 {int a; int b; int c;}; int f(int a, struct s3 s) { return s.a+s.b+s.c-a; } int main() { struct s3 x={2,3,4}; return f(3, x); }"
+assert 15 "struct s2 {int a; int b;}; int f(int a, int b, int c, int d, int e, struct s2 s) { return a+s.a+s.b; } int main() { struct s2 x={4,10}; return f(1,2,3,4,5,x); }"
+assert 3 "struct c3 {char a; char b; char c;}; int f(struct c3 s, int d) { return s.c; } int main() { struct c3 x={1,2,3}; return f(x, 9); }"
+assert 12 "struct s2 {int a; int b;}; struct s2 f(int a, int b) { struct s2 s={a,b}; return s; } int main() { struct s2 x=f(5,7); return x.a+x.b; }"
+assert 7 "struct s3 {int a; int b; int c;}; struct s3 f(int a) { struct s3 s={a,a+1,a+2}; return s; } int main() { return f(2).c + f(1).c; }"
+assert 42 "struct s2 {int a; int b;}; struct s2 sw(struct s2 s, int k) { struct s2 r={s.b+k, s.a}; return r; } int call_s2(struct s2 (*f)(struct s2, int)); int main() { return call_s2(sw); }"
+assert 9 "struct s3 {int a; int b; int c;}; struct s3 dbl(struct s3 s) { struct s3 r={s.a*2, s.b, s.c}; return r; } int call_s3(struct s3 (*f)(struct s3)); int main() { return call_s3(dbl) + 2; }"
+assert 5 "int main() { return 3.5 + 1.5; }"
+assert 10 "int main() { double x=2.5; return x*4; }"
+assert 3 "int main() { float f=1.5; double d=f; return d*2; }"
+assert 1 "int main() { return 7.0/2 == 3.5; }"
+assert 0 "int main() { return 0.1 + 0.2 == 0.3; }"
+assert 1 "int main() { return 0.1 + 0.2 != 0.3; }"
+assert 1 "int main() { return 1.5 < 2; }"
+assert 1 "int main() { return 2.0 <= 2; }"
+assert 0 "int main() { return 3.0 > 4; }"
+assert 1 "int main() { return 2 >= 1.5f; }"
+assert 12 "int main() { return 0x1.8p3; }"
+assert 2 "int main() { return .5 * 4; }"
+assert 10 "int main() { return 1e2/10; }"
+assert 4 "int main() { return 5.e-1*8; }"
+assert 48 "int main() { return sizeof(float)*10 + sizeof(1.0); }"
+assert 4 "int main() { return sizeof(1.0f); }"
+assert 2 "int main() { if (0.0) return 1; return 2; }"
+assert 2 "int main() { if (-0.0) return 1; return 2; }"
+assert 3 "int main() { double x=0.5; while (x) return 3; return 4; }"
+assert 3 "int main() { return (int)-2.7 + 5; }"
+assert 65 "int main() { char c = 65.9; return c; }"
+assert 7 "int main() { int i = 3; double d = i; float f = d + 0.5; return f*2; }"
+assert 5 "int main() { double d = 1 ? 2.5 : 1; return d*2; }"
+assert 14 "double g = 1.5; float h = 2.25f; int i = 2.9; int main() { return g*2 + h*4 + i; }"
+assert 6 "double ga[] = {1, 2.5, (float)2.5}; int main() { return ga[0] + ga[1] + ga[2]; }"
+assert 4 "int g[(int)4.9]; int main() { return sizeof(g)/8; }"
+assert 4 "double half(double x) { return x/2; } int main() { return half(9); }"
+assert 6 "float f(float a, double b) { return a*b; } int main() { return f(1.5, 4); }"
+assert 45 "double sum9(double a, double b, double c, double d, double e, double f, double g, double h, double i) { return a+b+c+d+e+f+g+h+i; } int main() { return sum9(1,2,3,4,5,6,7,8,9); }"
+assert 21 "double f(int a, double b, int c, float d, int e, double f) { return a+b+c+d+e+f; } int main() { return f(1, 2.5, 3, 4.5, 5, 5); }"
+assert 6 "double add_double(double x, double y); int main() { return add_double(2.5, 3.5); }"
+assert 4 "float add_float(float x, float y); int main() { return add_float(1.25, 2.75); }"
+assert 34 "int mixed(int a, double b, int c, float d); int main() { return mixed(1, 2.5, 3, 0.5); }"
+assert 65 "double sum10d(); int main() { return sum10d(1.0,2.0,3.0,4.0,5.0,6.0,7.0,8.0,9.0,10.0); }"
+assert 4 "struct d2 {double x; double y;}; double sum_d2(struct d2 s); int main() { struct d2 s={1.5, 2.5}; return sum_d2(s); }"
+assert 6 "struct f3 {float x; float y; float z;}; float sum_f3(struct f3 s); int main() { struct f3 s={1, 2, 3.5}; return sum_f3(s); }"
+assert 8 "struct dl {double d; int l;}; double sum_dl(struct dl s); int main() { struct dl s={2.5, 3}; return sum_dl(s); }"
+assert 9 "struct d2 {double x; double y;}; struct d2 make_d2(double x, double y); int main() { return make_d2(1.5, 2.25).y*4; }"
+assert 3 "struct f3 {float x; float y; float z;}; struct f3 make_f3(float x, float y, float z); int main() { return make_f3(1, 2, 3).z; }"
+assert 12 "struct dl {double d; int l;}; struct dl make_dl(double d, int l); int main() { struct dl s=make_dl(2.5, 7); return s.d*2 + s.l; }"
+assert 26 "struct d2 {double x; double y;}; struct d2 g(struct d2 s, double k) { struct d2 r={s.y+k, s.x}; return r; } double call_d2(struct d2 (*f)(struct d2, double)); int main() { return call_d2(g); }"
+assert 7 "struct dl {double d; int l;}; struct dl f(double d, int l) { struct dl r={d, l}; return r; } int main() { struct dl x=f(1.5, 4); return x.d*2 + x.l; }"
+assert 10 "struct f3 {float x; float y; float z;}; float f(struct f3 s) { return s.x+s.y+s.z; } int main() { struct f3 s={1.5, 2.5, 6}; return f(s); }"
+assert 103 "int sprintf(); int main() { char buf[10]; float f=2.5; sprintf(buf, \"%.1f\", f); return buf[0]+buf[2]; }"
+assert 7 "#include <stdarg.h>
+double sumd(int n, ...) { va_list ap; va_start(ap, n); double s=0; while (n) { s=s+va_arg(ap, double); n=n-1; } va_end(ap); return s; } int main() { return sumd(3, 1.5, 2.5, 3.0); }"
+assert 95 "#include <stdarg.h>
+double sumd(double x, int n, ...) { va_list ap; va_start(ap, n); double s=x; while (n) { s=s+va_arg(ap, double)+va_arg(ap, int); n=n-1; } va_end(ap); return s; } int main() { return sumd(0.5, 9, 1.5,1, 2.5,2, 3.5,3, 4.5,4, 5.5,5, 6.5,6, 7.5,7, 8.5,8, 9.5,9); }"
+assert 20 "int main() { int n=5; int a[n]; int i; for (i=0; i<n; i=i+1) a[i]=i*2; return a[0]+a[1]+a[2]+a[3]+a[4]; }"
+assert 40 "int main() { int n=5; int a[n]; return sizeof(a); }"
+assert 24 "int main() { int n=3; return sizeof(int[n]); }"
+assert 48 "int main() { int n=2; int m=3; int a[n][m]; return sizeof(a); }"
+assert 24 "int main() { int n=2; int m=3; int a[n][m]; return sizeof(a[1]); }"
+assert 12 "int main() { int n=2; int m=3; int a[n][m]; a[1][2]=7; a[0][1]=5; return a[1][2]+a[0][1]; }"
+assert 30 "int main() { int n=3; char a[n][10]; return sizeof(a); }"
+assert 9 "int main() { int n=3; int a[2][n]; a[1][2]=9; return *(*(a+1)+2); }"
+assert 3 "int main() { int n=3; int a[n]; int (*p)[n]=&a; return sizeof(*p)/8; }"
+assert 8 "int main() { int n=4; int b[n]; int *p=b; p[3]=8; return b[3]; }"
+assert 1 "int main() { int n=10; int x=1; int a[n]; return x; }"
+assert 15 "int sum(int n) { int a[n]; int i; for (i=0; i<n; i=i+1) a[i]=i; int s=0; for (i=0; i<n; i=i+1) s=s+a[i]; return s; } int main() { return sum(6); }"
+assert 1 "int main() { int i; int n=4; int *p; int *q; for (i=0; i<100; i=i+1) { int a[n]; if (i==0) p=a; q=a; } return p==q; }"
+assert 1 "int main() { int n=3; char *p; char *q; { int a[n]; p=a; } { int b[n]; q=b; } return p==q; }"
+assert 1 "int main() { int n=5; int a[n]; return (int)a/16*16 == (int)a; }"
+assert 11 "int main() { int n=2; int x=({ int a[n]; a[0]=5; a[1]=6; a[0]+a[1]; }); return x; }"
+assert 6 "int main() { char *p=__builtin_alloca(16); p[0]=1; p[15]=5; return p[0]+p[15]; }"
+assert 21 "int add6(); int main() { return add6(1, 2, ({ char *p=__builtin_alloca(32); p[0]=3; p[0]; }), 4, 5, 6); }"
+assert 7 "int main() { int x; x = 3 + *(int *)__builtin_alloca(8) * 0 + 4; return x; }"
+assert 2 "int main() { char *p=__builtin_alloca(5); char *q=__builtin_alloca(1); return ((int)p/16*16 == (int)p) + ((int)q/16*16 == (int)q); }"
+assert 5 "extern int table[]; int main() { return table[1]; } int table[] = {4, 5, 6};"
+assert 24 "extern int table[]; int table[3]; int main() { return sizeof(table); }"
+assert 16 "int g[]; int g[2]; int main() { return sizeof(g); }"
+assert 8 "int g[]; int main() { g[0]=3; return sizeof(g); }"
+assert 98 "int f(char s[]) { return s[1]; } int main() { return f(\"abc\"); }"
+assert 16 "int f(int a[][2]) { return sizeof(*a)/2; } int main() { int x[2][2]; return f(x)*2; }"
+assert 8 "struct s { int n; char d[]; }; int main() { return sizeof(struct s); }"
+assert 8 "struct s { char c; int d[]; }; int main() { return sizeof(struct s); }"
+assert 7 "struct s { int n; int d[]; }; int main() { struct s *p = __builtin_alloca(32); p->d[2]=3; p->n=4; return p->d[2]+p->n; }"
+assert 14 "struct s { int n; int d[]; }; struct s g = {3, {4, 5}}; struct s h = {1}; int main() { return g.d[1] + sizeof(struct s) + h.n; }"
+assert 98 "struct s { int n; char d[]; }; struct s g = {3, \"ab\"}; int main() { return g.d[1]; }"
+assert 3 "struct s { int n; int d[]; }; int main() { struct s x = {3}; return x.n; }"
+assert 8 "int main() { struct { unsigned a:3; int b:5; } s; return sizeof(s); }"
+assert 2 "int main() { struct { char c; int :4; } s; return sizeof(s); }"
+assert 2 "int bf_size2(); int main() { return bf_size2(); }"
+assert 2 "int main() { struct { char a:3; char b:6; } s; return sizeof(s); }"
+assert 16 "struct bf { unsigned a:3; int b:5; unsigned :0; unsigned char c:4; int d:60; }; int main() { return sizeof(struct bf); }"
+assert 16 "int bf_size(); int main() { return bf_size(); }"
+assert 1 "int main() { struct { int a:3; } s; s.a = 7; return s.a + 2; }"
+assert 7 "int main() { struct { unsigned a:3; } s; s.a = 15; return s.a; }"
+assert 1 "int main() { struct { unsigned a:3; } s; return (s.a = 9); }"
+assert 60 "int main() { struct { int a:4; int b:4; int c; } s; s.a=3; s.b=5; s.c=9; s.a=1; return s.b*10 + s.a + s.c; }"
+assert 19 "struct { int a:4; unsigned b:4; char c; } g = {-2, 11, 7}; int main() { return (g.a == -2) + g.b + g.c; }"
+assert 190 "struct { int a:4; unsigned b:4; char c; } g = {-2, 11, 7}; int main() { return *(unsigned char *)&g; }"
+assert 34 "int main() { struct { int a:4; unsigned b:4; } s = {3, 4}; return s.a*10 + s.b; }"
+assert 12 "struct bf { unsigned a:3; int b:5; unsigned :0; unsigned char c:4; int d:60; }; int bf_fill(struct bf *p); int main() { struct bf s; bf_fill(&s); return s.a + s.b + s.c + (s.d == -12345); }"
+assert 1 "struct bf { unsigned a:3; int b:5; unsigned :0; unsigned char c:4; int d:60; }; int bf_check(struct bf *p); int main() { struct bf s; s.a=6; s.b=-2; s.c=15; s.d=77; return bf_check(&s); }"
+assert 5 "struct bf { int x; int f:3; }; int main() { struct bf s = {.f = 2, .x = 3}; return s.x + s.f; }"
+assert 1 "int main() { unsigned x = 0; x = x - 1; return x > 5; }"
+assert 0 "int main() { int x = 0; x = x - 1; return x > 5; }"
+assert 100 "int main() { unsigned char c = 200; return c / 2; }"
+assert 1 "int main() { return (unsigned)-8 / 2 > 1000; }"
+assert 201 "int main() { unsigned char c = 200; signed char d = c; return c + (d == -56); }"
+assert 3 "_Static_assert(sizeof(int) == 8, \"int is 8 bytes\"); int main() { return 3; }"
+assert 4 "int main() { _Static_assert(1 + 1 == 2, \"math\"); return 4; }"
+assert 5 "int main() { _Static_assert(sizeof(char)); return 5; }"
+assert 8 "struct s { int a; _Static_assert(sizeof(int) == 8, \"x\"); }; int main() { return sizeof(struct s); }"
+assert 1 "int main() { return _Generic(1, char: 0, int: 1, default: 2); }"
+assert 0 "int main() { char c; return _Generic(c, char: 0, int: 1, default: 2); }"
+assert 2 "int main() { return _Generic(1.0, char: 0, int: 1, default: 2); }"
+assert 3 "int main() { return _Generic(1.0f, double: 2, float: 3); }"
+assert 4 "int main() { int x[3]; return _Generic(x, int *: 4, default: 5); }"
+assert 5 "int main() { int *p; return _Generic(p, char *: 4, default: 5); }"
+assert 6 "int main() { unsigned u; return _Generic(u, int: 5, unsigned: 6); }"
+assert 9 "struct s { int a; }; struct t { int a; }; int main() { struct s v; return _Generic(v, struct t: 8, struct s: 9); }"
+assert 8 "int main() { return sizeof(_Generic(1, char: (char)1, default: 1)) + _Generic(1, int: 0); }"
+assert 16 "int g[_Generic(1, int: 2, default: 3)]; int main() { return sizeof(g); }"
+assert 10 "int main() { int x = 10; return _Generic(x, int: x, default: 0); }"
+assert 3 "int main() { const int x = 3; return x; }"
+assert 4 "int main() { int const x = 4; return x; }"
+assert 5 "const int g = 5; int main() { return g; }"
+assert 98 "int main() { const char *s = \"abc\"; return s[1]; }"
+assert 7 "int main() { int x = 3; int *const p = &x; *p = 7; return x; }"
+assert 8 "int main() { int x = 8; const int *p = &x; const int *const *pp = &p; return **pp; }"
+assert 9 "int main() { volatile int x = 4; x = x + 5; return x; }"
+assert 6 "int main() { int a = 6; int *restrict p = &a; return *p; }"
+assert 6 "int f(int *__restrict p, const char *restrict q) { return *p + *q; } int main() { int a = 5; char c = 1; return f(&a, &c); }"
+assert 3 "int main() { const unsigned char c = 3; return c; }"
+assert 1 "int main() { const int x = 1; return _Generic(x, int: 1, default: 2); }"
+assert 2 "int main() { const int *p; return _Generic(p, int *: 1, const int *: 2); }"
+assert 12 "struct s { const int a; int b; }; int main() { struct s v = {5, 7}; return v.a + v.b; }"
+assert 4 "struct s { int a; }; int main() { const struct s v = {4}; return v.a; }"
+assert 8 "int main() { return sizeof(const int) + sizeof(volatile char) - 1; }"
+assert 2 "int main() { const int a[] = {1, 2}; return a[1]; }"
+assert 3 "int main() { char *p = (char *)(const char *)\"abc\"; return (const int)3; }"
+assert 1 "int main() { char c; int x; c = 1; x = 2; return (int)&x / 8 * 8 == (int)&x; }"
+assert 1 "int main() { char c; _Alignas(16) char x; return (int)&x / 16 * 16 == (int)&x; }"
+assert 1 "int main() { char c; char x __attribute__((aligned(32))); char d; return (int)&x / 32 * 32 == (int)&x; }"
+assert 1 "int main() { char c; _Alignas(64) char x; char d; return (int)&x / 64 * 64 == (int)&x; }"
+assert 8 "int f(int n) { char c; _Alignas(64) char x; if (n == 0) return 0; return f(n-1) + ((int)&x / 64 * 64 == (int)&x); } int main() { return f(8); }"
+assert 38 "int f(int a, int b, int c, int d, int e, int f, int g, int h) { _Alignas(64) int x = 1; return g * 4 + h + x + ((int)&x / 64 * 64 == (int)&x); } int main() { return f(1, 2, 3, 4, 5, 6, 7, 8); }"
+assert 24 "int sum(int n, ...) { _Alignas(128) char x; __builtin_va_list ap; __builtin_va_start(ap, n); int s=(int)&x / 128 * 128 == (int)&x; int i; for (i=0; i<n; i=i+1) s=s+__builtin_va_arg(ap, int); __builtin_va_end(ap); return s; } int main() { return sum(9, 1, 2, 3, 4, 5, 1, 2, 3, 2); }"
+assert 1 "int main() { char c; _Alignas(double) char x; return (int)&x / 8 * 8 == (int)&x; }"
+assert 1 "char g1; int g2; int main() { return (int)&g2 / 8 * 8 == (int)&g2; }"
+assert 1 "char g1; _Alignas(64) char g2 = 3; int main() { return (int)&g2 / 64 * 64 == (int)&g2; }"
+assert 1 "char g1; static char g2 __attribute__((aligned(32))); int main() { return (int)&g2 / 32 * 32 == (int)&g2; }"
+assert 32 "struct s { char a; _Alignas(16) char b; }; int main() { return sizeof(struct s); }"
+assert 16 "struct s { char a; _Alignas(16) char b; }; int main() { struct s v; return (int)&v.b - (int)&v; }"
+assert 16 "struct s { char a; char b __attribute__((aligned(8))); }; int main() { return sizeof(struct s); }"
+assert 16 "struct s { char a; } __attribute__((aligned(16))); int main() { return sizeof(struct s); }"
+assert 8 "struct __attribute__((packed, aligned(8))) s { char a; }; int main() { return sizeof(struct s); }"
+assert 1 "int main() { static _Alignas(32) char x; return (int)&x / 32 * 32 == (int)&x; }"
+assert 5 "int f(int x) __attribute__((noinline, section(\"text\"))); int f(int x) { return x; } int main() { return f(5); }"
+assert 7 "int main() { int x = 3; int y; asm(\"mov %0, %1\" : \"=r\"(y) : \"r\"(x)); return y + 4; }"
+assert 15 "int main() { int z = 5; asm volatile(\"add %0, %1\" : \"+r\"(z) : \"i\"(10)); return z; }"
+assert 42 "int main() { int m = 1; asm(\"add %0, 41\" : \"+m\"(m)); return m; }"
+assert 6 "int main() { int m = 5; int *p = &m; __asm__(\"inc %0\" : \"+m\"(*p)); return m; }"
+assert 3 "int main() { char c = 1; asm(\"add %0, 2\" : \"+r\"(c)); return c; }"
+assert 9 "int main() { int x = 4; int w; asm(\"lea %q[out], [%[in]+5]\" : [out] \"=r\"(w) : [in] \"r\"(x) : \"rax\", \"cc\"); return w; }"
+assert 1 "int main() { int lo; int hi; asm volatile(\"rdtsc\" : \"=a\"(lo), \"=d\"(hi)); return hi >= 0; }"
+assert 1 "int main() { int a; int b; int c; int d; asm(\"cpuid\" : \"=a\"(a), \"=b\"(b), \"=c\"(c), \"=d\"(d) : \"0\"(0)); return a > 0; }"
+assert 8 "int main() { int x = 8; int y; asm(\"mov %0, %1\" \"\\n  nop\" : \"=r\"(y) : \"m\"(x)); return y; }"
+assert 5 "int main() { asm(\"nop\"); __asm(\"nop\" ::: \"memory\"); return 5; }"
+assert 1 "asm(\".globl my_getpid\\nmy_getpid:\\n  mov rax, 39\\n  syscall\\n  ret\"); int my_getpid(); int getpid(); int main() { return my_getpid() == getpid(); }"
+assert 5 "_Thread_local int x = 5; int main() { return x; }"
+assert 4 "__thread int x; int main() { x = 4; return x; }"
+assert 16 "_Thread_local int x = 5; int f() { x = x + 1; return x; } int main() { x = 10; return run_thread(f) + x; }"
+assert 10 "__thread int x; int f() { return x; } int main() { x = 10; return run_thread(f) + x; }"
+assert 7 "extern __thread int tls1; int main() { return tls1; }"
+assert 20 "extern _Thread_local int tls1; int f() { tls1 = tls1 * 2; return tls1; } int main() { tls1 = 1; return run_thread(f) + get_tls1() + tls1 * 5; }"
+assert 3 "static __thread int z = 3; int main() { int *p = &z; return *p; }"
+assert 9 "int f() { static _Thread_local int n; n = n + 1; return n; } int main() { f(); f(); return run_thread(f) * 3 + f() * 2; }"
+assert 8 "int main() { extern __thread int tls1; return tls1 + 1; }"
+assert 9 "struct __attribute__((packed)) P { char a; int b; }; int main() { return sizeof(struct P); }"
+assert 1 "struct P { char a; int b; } __attribute__((__packed__)); int main() { struct P p; return (int)&p.b - (int)&p; }"
+assert 10 "struct Q { char a; int b __attribute__((packed)); char c; }; int main() { return sizeof(struct Q); }"
+assert 12 "struct R { char a; int b; } __attribute__((packed, aligned(4))); int main() { return sizeof(struct R); }"
+assert 7 "int sec_var __attribute__((section(\".mydata\"))) = 7; int main() { return sec_var; }"
+assert 2 "int f() __attribute__((section(\".text.hot\"))); int f() { return 2; } int main() { return f(); }"
+assert 3 "int weak_var __attribute__((weak)) = 3; int main() { return weak_var; }"
+assert 1 "extern int nosuch() __attribute__((weak)); int main() { return &nosuch == 0; }"
+assert 21 "int counter; __attribute__((constructor)) int init1() { counter = counter * 10 + 1; return 0; } int init2() __attribute__((constructor(200))); int init2() { counter = counter * 10 + 2; return 0; } int main() { return counter; }"
+assert 40 "int real() { return 40; } int alias_fn() __attribute__((alias(\"real\"))); int main() { return alias_fn(); }"
+assert 6 "int real_var = 6; int alias_var __attribute__((alias(\"real_var\"))); int main() { return alias_var; }"
+assert 3 "int exit(); int die(int c) __attribute__((noreturn)); int die(int c) { exit(c); } int main() { die(3); }"
+assert 4 "int f(int x __attribute__((unused))) { int y __attribute__((unused, used)); return x; } int main() { return f(4); }"
+assert 5 "int main() { int x __attribute__((deprecated(\"old\"), unknown_attr)) = 5; return x; }"
+assert 97 "int main() { return 'a'; }"
+assert 10 "int main() { return '\\n'; }"
+assert 1 "int main() { return '\\xff' == -1; }"
+assert 65 "int main() { return '\\101'; }"
+assert 8 "int main() { return sizeof('a'); }"
+assert 3 "int main() { return sizeof(\"é\"); }"
+assert 195 "int main() { char *s = \"é\"; return s[0] + 256; }"
+assert 169 "int main() { return \"é\"[1] + 256; }"
+assert 3 "int main() { return sizeof(u8\"é\") + sizeof(u8'a') - 1; }"
+assert 12 "int main() { return sizeof(L\"ab\"); }"
+assert 4 "int main() { return sizeof(L'a'); }"
+assert 233 "int main() { return L\"aé\"[1]; }"
+assert 1 "int main() { return L'€' == 8364; }"
+assert 6 "int main() { return sizeof(u\"ab\"); }"
+assert 2 "int main() { return sizeof(u'a'); }"
+assert 2 "int main() { return (u\"𝄞\"[0] == 55348) + (u\"𝄞\"[1] == 56606); }"
+assert 6 "int main() { return sizeof(u\"𝄞\"); }"
+assert 8 "int main() { return sizeof(U\"𝄞\"); }"
+assert 1 "int main() { return U\"𝄞\"[0] == 119070; }"
+assert 1 "int main() { return U'𝄞' == 119070; }"
+assert 2 "int main() { return (L'\\xffffffff' < 0) + (U'\\xffffffff' > 0); }"
+assert 5 "int wcslen(); int main() { return wcslen(L\"héllo\"); }"
+assert 2 "int main() { char s[] = \"é\"; return sizeof(s) - 1; }"
+assert 3 "int café = 3; int main() { return caf\\u00e9; }"
+assert 5 "int f\\u00e9(int ä) { return ä + 2; } int main() { return fé(3); }"
+assert 4 "struct π { int ω; }; int main() { struct π p; p.\\U000003c9 = 4; return p.ω; }"
+assert 10 "int main() { int 変数 = 10; return 変数; }"
+assert 7 "int main() { int intä = 7; return intä; }"
+assert 1 "int main() { int a\\u0301 = 1; return a\\u0301; }"
+assert 4 "int main() { return sizeof(\"\\u00e9\") + sizeof(\"\\U0001D11E\") - 4; }"
+assert 1 "int main() { return L'\\u20ac' == 8364; }"
+assert 5 $'int main() {\r\n  return 2 +\r\n 3;\r\n}'
+assert 5 $'int main() {\r  return 2 + 3;\r}'
+assert 7 $'int main() { int x = 3; re\\\nturn x + \\\r\n4; }'
+assert 2 $'int main() { char *s = "ab\\\ncd"; return s[3] - s[0] - 1; }'
+assert 3 $'int main() { // a comment \\\n continued\n return 3; }'
+assert 6 $'#include <stdarg.h>\r\nint main() { return 6; }'
+assert 7 "int main() { int a[3]; a[1] = 7; int (*p)[3] = &a; return (*p)[1]; }"
+assert 24 "int main() { int a[3]; int (*p)[3] = &a; return sizeof(*p); }"
+assert 40 "int f(int x) { return x*2; } int main() { int (*fns[4])(int); fns[2] = f; return fns[2](4) + sizeof(fns); }"
+assert 3 "int f(void) { return 3; } int main() { int (*g)(void) = f; return g(); }"
+assert 65 "int f(int argc, char *argv[]) { return argv[1][0]; } int main() { char *a[2]; a[1] = \"A\"; return f(2, a); }"
+assert 7 "int sum(int n, int (*m)[2]) { return m[n][0] + m[n][1]; } int main() { int m[3][2]; m[2][0] = 3; m[2][1] = 4; return sum(2, m); }"
+assert 48 "int main() { int (x[2])[3]; return sizeof(x); }"
+assert 28 "int main() { int *a[3]; int x = 4; a[2] = &x; return *a[2] + sizeof(a); }"
+assert 5 "int main() { int x[2][3]; x[1][2] = 5; return ((int (*)[3])x)[1][2]; }"
+assert 32 "int main() { return sizeof(int (*)[3]) + sizeof(int *[3]); }"
+assert 1 "int f(int x) { return x; } int main() { return _Generic(f, int (*)(int): 1, default: 2); }"
+assert 3 "int f(int x) { return x; } int main() { int (*g)(int) = f; return _Generic(g, int (*)[3]: 1, int (*)(int): 3, default: 2); }"
+assert 9 "int g[2][3]; int (*gp)[3] = g; int main() { gp[1][2] = 9; return g[1][2]; }"
+assert 6 "int f(int x) { return x+1; } int (*gf[2])(int) = {f, f}; int main() { return gf[1](5); }"
+assert 3 "int main() { int a = 1, *b = &a, c[4]; *b = 3; return a; }"
+assert 49 "int main() { int a = 1, *b = &a, c[4]; return sizeof(a) + sizeof(b) + sizeof(c) + *b; }"
+assert 7 "int main() { int x = 3, y = x + 4; return y; }"
+assert 6 "int main() { int a, b, c; a = 1; b = 2; c = 3; return a + b + c; }"
+assert 5 "int main() { char s[] = \"ab\", *p = s; int n = 3; return sizeof(s) + n - 1 + (p[1] - 'b'); }"
+assert 9 "int main() { static int a = 4, b = 5; return a + b; }"
+assert 10 "int main() { int n = 2, v[n], m = 3; v[1] = 5; return v[1] + m + n; }"
+assert 6 "int a = 1, *b = &a, c[4] = {2, 3}; int main() { return *b + c[0] + c[1]; }"
+assert 48 "int a, *b, c[4]; int main() { return sizeof(a) + sizeof(b) + sizeof(c); }"
+assert 6 "int f(int x), g = 5; int main() { return f(g); } int f(int x) { return x + 1; }"
+assert 7 "int main() { int f(int), x = 6; return f(x); } int f(int x) { return x + 1; }"
+assert 12 "int main() { struct { int x; } s = {5}, *p = &s, t[2]; return p->x + sizeof(t) - 9; }"
+assert 200 "int x = (unsigned char)200; int main() { return x; }"
+assert 1 "int x = (char)200; int main() { return x == -56; }"
+assert 1 "int main() { static int x = (unsigned char)-1; return x == 255; }"
+assert 1 "int main() { int a[(unsigned char)-1 + 1]; return sizeof(a) / 8 == 256; }"
+assert 7 "static int ret3(); int ret3() { return 7; } int main() { return ret3(); }"
+assert 9 "static int ret5(); extern int ret5(); int ret5() { return 9; } int main() { return ret5(); }"
+assert 9 "static int ext1 = 9; extern int ext1; int main() { return ext1; }"
+assert 1 "int main() { int a; int b; int c; int d; asm(\"cpuid\" : \"=a\"(a), \"=b\"(b), \"=c\"(c), \"=d\"(d) : \"a\"(0), \"c\"(0)); return a > 0; }"
+assert 1 "int getpid(); int main() { int ret; asm volatile(\"syscall\" : \"=a\"(ret) : \"a\"(39) : \"rcx\", \"r11\", \"memory\"); return ret == getpid(); }"
+assert 9 "int main() { int ret; char *s = \"abc\"; asm volatile(\"syscall\" : \"=a\"(ret) : \"a\"(1), \"D\"(-1), \"S\"(s), \"d\"(3) : \"rcx\", \"r11\", \"memory\"); return -ret; }"
+assert 1 "int main() { unsigned u = 0; u = u - 1; double d = u; return d > 1.8e19; }"
+assert 1 "int main() { unsigned u = 0; u = u - 1; float f = u; return f > 1.8e19; }"
+assert 1 "int main() { unsigned u = (unsigned)1e19; return u / 1000000000000000000 == 10; }"
+assert 1 "int main() { double d = 1e19; unsigned u = d; return u == 10000000000000000000; }"
+assert 1 "int main() { float f = 1e19; unsigned u = f; return u / 1000000000000 == 9999999; }"
+assert 1 "double g = (unsigned)-1; int main() { return g > 1.8e19; }"
+assert 1 "unsigned g = (unsigned)1e19; int main() { return g == 10000000000000000000; }"
+assert 1 "int main() { unsigned u = 9223372036854775809; double d = u; return d == 9223372036854775808.0; }"
+assert 1 "struct P { int x; }; int main() { struct P (*p); return _Generic(*p, struct P: 1, default: 2); }"
+assert 5 "struct P { int x; }; int main() { struct P s; struct P (*p) = &s; p->x = 5; return s.x; }"
+assert 1 "struct P { int x; }; struct P (*gp); int main() { return _Generic(gp, struct P *: 1, default: 2); }"
+assert_asm 'keep:' "static int keep __attribute__((used)) = 3; int main() { return 0; }"
+assert_asm 'helper:' "static int helper() __attribute__((used)); static int helper() { return 1; } int main() { return 0; }"
+assert_asm '.section mydata,"awR",@progbits' "int x __attribute__((used, section(\"mydata\"))) = 1; int main() { return 0; }"
+assert_asm '.section mytext,"axR",@progbits' "__attribute__((used, section(\"mytext\"))) static int f() { return 1; } int main() { return 0; }"
+assert 4 "static int keep __attribute__((used, section(\"keepsec\"))) = 4; int main() { return keep; }"
+assert_error 'invalid application of sizeof to an incomplete type' "extern int t[]; int main() { return sizeof(t); }"
+assert_error 'invalid application of sizeof to an incomplete type' "int main() { return sizeof(int[]); }"
+assert 24 "extern int t[]; int t[3]; int main() { return sizeof(t); }"
+assert_warn "excess elements in initializer" "int main() { int a[2] = {1, 2, 3}; return a[0]; }"
+assert_warn "unknown attribute 'frobnicate' ignored" "int x __attribute__((frobnicate(1))); int main() { return 0; }"
+assert_warn "assignment discards 'const' qualifier from pointer target type" "int main() { const int x = 1; int *p = &x; return 0; }"
+assert_warn "assignment discards 'volatile' qualifier from pointer target type" "int f(int *p) { return 0; } int main() { volatile int x; return f(&x); }"
+assert_error 'undefined variable' "int main() { return y; }"
+assert_warn "assignment discards 'const' qualifier from pointer target type" "int f(int *p) { return 0; } int main() { const int x = 1; int r = f(&x); return r; }"
+assert_warn "assignment discards 'const' qualifier from pointer target type" "int main() { const int x = 1; int *p; return _Generic(p = &x, int *: 0); }"
+assert_warn "assignment discards 'const' qualifier from pointer target type" "int f(int *p) { return 0; } int main() { const int x = 1; int a[2] = {0, f(&x)}; return a[1]; }"
+assert_warn "assignment discards 'const' qualifier from pointer target type" "struct S { int a; }; struct S h(int *p) { struct S s = {1}; return s; } int main() { const int x = 1; struct S s = h(&x); return s.a; }"
+assert_warn "assignment discards 'const' qualifier from pointer target type" "int f(int *p) { return 2; } int main() { const int x = 1; int a[f(&x)]; return sizeof(a); }"
+assert_warn "excess elements in initializer" "int main() { int a[2] = {1, 2, 3, 4, 5}; return a[0]; }"
+assert_warn "excess elements in initializer" "struct S { int x; }; int main() { struct S s = {1, 2, 3}; return s.x; }"
+assert_warn "excess elements in initializer" "int g[1] = {1, 2, 3}; int main() { return g[0]; }"
 echo OK
\ No newline at end of file
@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type tokenKind int
@@ -22,49 +26,126 @@ type token struct {
 	val      int
 	str      []rune
 	len      int
+	name     []rune
 	contents []rune
 	contLen  int
+	fval     float64
+	ty       *typ
 }
 
 var (
 	t        = &token{}
 	filename = ""
 	inpt     = ""
+	stdarg   = false
+	// srcPos maps offsets in the normalized source back to inpt.
+	srcPos []int
 )
 
-func errorAt(loc []rune, f string, r ...[]rune) {
+func verrorAt(loc []rune, f string, r ...[]rune) {
 	k := []rune(inpt)
-	line := 1
-	buf := make([]rune, 0)
-	for len(k) > 0 {
-		if k[0] == '\n' {
-			if len(k) <= len(loc)+2 {
-				break
-			}
-			line++
-			buf = make([]rune, 0)
+	pos := len(k) - len(loc)
+	if i := len(srcPos) - 1 - len(loc); srcPos != nil && i >= 0 {
+		pos = srcPos[i]
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	line, start, end := locate(k, pos)
+	// Keep tabs in the indentation so that the caret lines up.
+	indent := make([]rune, 0, pos-start)
+	for _, c := range k[start:pos] {
+		if c == '\t' {
+			indent = append(indent, '\t')
 		} else {
-			buf = append(buf, k[0])
+			indent = append(indent, ' ')
 		}
-		k = k[1:]
 	}
-	e := fmt.Errorf("%s:%d", filename, line)
-	fmt.Fprintln(os.Stderr, e)
-	p := len(buf)
-	e = fmt.Errorf(string(buf))
-	fmt.Fprintln(os.Stderr, e)
-	e = fmt.Errorf("%*s", p, "")
-	fmt.Fprint(os.Stderr, e)
+	fmt.Fprintf(os.Stderr, "%s:%d\n", filename, line)
+	fmt.Fprintln(os.Stderr, string(k[start:end]))
+	fmt.Fprint(os.Stderr, string(indent))
 	fmt.Fprintln(os.Stderr, "^ ")
+	var e error
 	if len(r) == 0 {
 		e = fmt.Errorf(f)
 	} else {
 		e = fmt.Errorf(f, string(r[0]))
 	}
 	fmt.Fprintln(os.Stderr, e)
+}
+
+// locate returns the line number of position pos in the original
+// source k and the bounds of that line. LF, CRLF and a lone CR all end
+// a line, as in normalize.
+func locate(k []rune, pos int) (int, int, int) {
+	isEOL := func(c rune) bool { return c == '\n' || c == '\r' }
+	start := pos
+	for start > 0 && !isEOL(k[start-1]) {
+		start--
+	}
+	end := pos
+	for end < len(k) && !isEOL(k[end]) {
+		end++
+	}
+	line := 1
+	for i, c := range k[:start] {
+		if c == '\n' || c == '\r' && (i+1 >= len(k) || k[i+1] != '\n') {
+			line++
+		}
+	}
+	return line, start, end
+}
+
+// normalize performs translation phases 1 and 2 on the source s: CRLF
+// and lone CR line endings become LF, backslash-newline pairs are
+// removed and a missing final newline is added. It records in srcPos
+// where each rune of the result came from in s, for diagnostics.
+func normalize(s string) []rune {
+	p := []rune(s)
+	r := make([]rune, 0, len(p)+1)
+	srcPos = make([]int, 0, len(p)+2)
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+1 < len(p) && (p[i+1] == '\n' || p[i+1] == '\r') {
+			i++
+			if p[i] == '\r' && i+1 < len(p) && p[i+1] == '\n' {
+				i++
+			}
+			continue
+		}
+		r = append(r, p[i])
+		srcPos = append(srcPos, i)
+		if p[i] == '\r' {
+			r[len(r)-1] = '\n'
+			if i+1 < len(p) && p[i+1] == '\n' {
+				i++
+			}
+		}
+	}
+	if len(r) == 0 || r[len(r)-1] != '\n' {
+		r = append(r, '\n')
+		srcPos = append(srcPos, len(p))
+	}
+	srcPos = append(srcPos, len(p))
+	return r
+}
+
+func errorAt(loc []rune, f string, r ...[]rune) {
+	verrorAt(loc, f, r...)
 	os.Exit(1)
 }
 
+// checkEncoding reports the first byte of the source s that is not part
+// of a valid UTF-8 sequence.
+func checkEncoding(s string) {
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 {
+			errorAt([]rune(s[i:]), "invalid UTF-8 sequence")
+		}
+		i += n
+	}
+}
+
 func errorTok(tok *token, f string, r ...[]rune) {
 	if tok != nil {
 		errorAt(tok.str, f, r...)
@@ -74,6 +155,12 @@ func errorTok(tok *token, f string, r ...[]rune) {
 	os.Exit(1)
 }
 
+func warnTok(tok *token, f string, r ...[]rune) {
+	if tok != nil {
+		verrorAt(tok.str, "warning: "+f, r...)
+	}
+}
+
 func peek(s []rune) bool {
 	if t.kind != tkReserved {
 		return false
@@ -125,7 +212,7 @@ func expectIdent() []rune {
 	if t.kind != tkIdent {
 		errorTok(t, "expected an identifier")
 	}
-	s := t.str[:t.len]
+	s := t.name
 	t = t.next
 	return s
 }
@@ -148,14 +235,23 @@ func startWith(str []rune, op []rune) bool {
 }
 
 func startWithReserved(str []rune) []rune {
-	kws := [8]string{"return", "if", "else", "while", "for", "int", "char", "sizeof"}
+	kws := [...]string{"return", "if", "else", "while", "for", "int", "void", "char", "float", "double", "signed", "unsigned", "sizeof", "struct", "static", "extern",
+		"__builtin_va_list", "__builtin_va_start", "__builtin_va_arg", "__builtin_va_end", "__builtin_va_copy", "__builtin_alloca", "_Static_assert", "_Generic", "default", "const", "volatile", "restrict", "__restrict", "__restrict__", "_Alignas", "__attribute__", "asm", "__asm__", "__asm", "__volatile__", "_Thread_local", "__thread"}
 	for _, kw := range kws {
 		l := len(kw)
-		if startWith(str, []rune(kw)) && !isAlNum(str[l]) {
+		if startWith(str, []rune(kw)) && !continuesIdent(str[l:]) {
 			return []rune(kw)
 		}
 	}
-	ops := [4]string{"==", "!=", "<=", ">="}
+	if stdarg {
+		for _, kw := range stdargKws {
+			l := len(kw)
+			if startWith(str, []rune(kw)) && !continuesIdent(str[l:]) {
+				return []rune(kw)
+			}
+		}
+	}
+	ops := [...]string{"...", "==", "!=", "<=", ">=", "->"}
 	for _, op := range ops {
 		if startWith(str, []rune(op)) {
 			return []rune(op)
@@ -164,6 +260,28 @@ func startWithReserved(str []rune) []rune {
 	return nil
 }
 
+var stdargKws = [...]string{"va_list", "va_start", "va_arg", "va_end", "va_copy"}
+
+func skipBlank(p []rune) []rune {
+	for len(p) > 0 && (p[0] == ' ' || p[0] == '\t') {
+		p = p[1:]
+	}
+	return p
+}
+
+func stdargInclude(p []rune) int {
+	q := skipBlank(p[1:])
+	if !startWith(q, []rune("include")) {
+		return 0
+	}
+	q = skipBlank(q[len("include"):])
+	if !startWith(q, []rune("<stdarg.h>")) && !startWith(q, []rune("\"stdarg.h\"")) {
+		return 0
+	}
+	q = q[len("<stdarg.h>"):]
+	return len(p) - len(q)
+}
+
 func isDigit(c rune) bool {
 	return c >= '0' && c <= '9'
 }
@@ -176,6 +294,101 @@ func isAlNum(c rune) bool {
 	return isAlpha(c) || isDigit(c)
 }
 
+// identRanges are the ranges of characters C11 Annex D allows in
+// identifiers, and identNonInitial those of them that may not start one.
+var (
+	identRanges = [...][2]rune{
+		{0xa8, 0xa8}, {0xaa, 0xaa}, {0xad, 0xad}, {0xaf, 0xaf}, {0xb2, 0xb5},
+		{0xb7, 0xba}, {0xbc, 0xbe}, {0xc0, 0xd6}, {0xd8, 0xf6}, {0xf8, 0xff},
+		{0x100, 0x167f}, {0x1681, 0x180d}, {0x180f, 0x1fff}, {0x200b, 0x200d},
+		{0x202a, 0x202e}, {0x203f, 0x2040}, {0x2054, 0x2054}, {0x2060, 0x206f},
+		{0x2070, 0x218f}, {0x2460, 0x24ff}, {0x2776, 0x2793}, {0x2c00, 0x2dff},
+		{0x2e80, 0x2fff}, {0x3004, 0x3007}, {0x3021, 0x302f}, {0x3031, 0x303f},
+		{0x3040, 0xd7ff}, {0xf900, 0xfd3d}, {0xfd40, 0xfdcf}, {0xfdf0, 0xfe44},
+		{0xfe47, 0xfffd}, {0x10000, 0x1fffd}, {0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+		{0x40000, 0x4fffd}, {0x50000, 0x5fffd}, {0x60000, 0x6fffd}, {0x70000, 0x7fffd},
+		{0x80000, 0x8fffd}, {0x90000, 0x9fffd}, {0xa0000, 0xafffd}, {0xb0000, 0xbfffd},
+		{0xc0000, 0xcfffd}, {0xd0000, 0xdfffd}, {0xe0000, 0xefffd},
+	}
+	identNonInitial = [...][2]rune{{0x300, 0x36f}, {0x1dc0, 0x1dff}, {0x20d0, 0x20ff}, {0xfe20, 0xfe2f}}
+)
+
+func inRanges(c rune, rs [][2]rune) bool {
+	for _, r := range rs {
+		if r[0] <= c && c <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdent1 and isIdent2 report whether c may start or continue an
+// identifier.
+func isIdent1(c rune) bool {
+	return isAlpha(c) || inRanges(c, identRanges[:]) && !inRanges(c, identNonInitial[:])
+}
+
+func isIdent2(c rune) bool {
+	return isAlNum(c) || inRanges(c, identRanges[:])
+}
+
+func isUCN(p []rune) bool {
+	return len(p) > 1 && p[0] == '\\' && (p[1] == 'u' || p[1] == 'U')
+}
+
+// continuesIdent reports whether p starts with an identifier character
+// or a universal character name.
+func continuesIdent(p []rune) bool {
+	return len(p) > 0 && (isIdent2(p[0]) || isUCN(p))
+}
+
+// readUCN decodes the universal character name \uXXXX or \UXXXXXXXX
+// whose u or U is at p. It returns the character and the number of runes
+// read.
+func readUCN(p []rune) (rune, int) {
+	n := 5
+	if p[0] == 'U' {
+		n = 9
+	}
+	if len(p) < n {
+		errorAt(p, "incomplete universal character name")
+	}
+	c := rune(0)
+	for _, d := range p[1:n] {
+		if !isHexDigit(d) {
+			errorAt(p, "incomplete universal character name")
+		}
+		v, _ := strconv.ParseInt(string(d), 16, 32)
+		c = c<<4 | rune(v)
+	}
+	if c < 0xa0 && c != '$' && c != '@' && c != '`' || 0xd800 <= c && c <= 0xdfff || c > 0x10ffff {
+		errorAt(p, "universal character name specifies an invalid character")
+	}
+	return c, n
+}
+
+// readIdent reads the identifier at p. It returns its name, with
+// universal character names decoded, and its length in the source.
+func readIdent(p []rune) ([]rune, int) {
+	var name []rune
+	l := 0
+	for l < len(p) {
+		c, n := p[l], 1
+		if isUCN(p[l:]) {
+			c, n = readUCN(p[l+1:])
+			n++
+			if !isIdent2(c) || l == 0 && !isIdent1(c) {
+				errorAt(p[l:], "universal character name is not valid in an identifier")
+			}
+		} else if !isIdent2(c) {
+			break
+		}
+		name = append(name, c)
+		l += n
+	}
+	return name, l
+}
+
 func isSpace(c rune) bool {
 	switch c {
 	case ' ':
@@ -269,6 +482,12 @@ func isReserved(c rune) bool {
 	case '[':
 		fallthrough
 	case ']':
+		fallthrough
+	case '?':
+		fallthrough
+	case ':':
+		fallthrough
+	case '.':
 		return true
 	default:
 		return false
@@ -299,34 +518,201 @@ func getEscapeChar(c rune) rune {
 	return c
 }
 
-func readStrLit(cur *token, p []rune) *token {
-	s := p
-	p = p[1:]
+func readFloatLit(cur *token, p []rune) *token {
 	l := 0
+	for l < len(p) {
+		if l+1 < len(p) && strings.ContainsRune("eEpP", p[l]) && (p[l+1] == '+' || p[l+1] == '-') {
+			l += 2
+		} else if isAlNum(p[l]) || p[l] == '.' {
+			l++
+		} else {
+			break
+		}
+	}
+	s := string(p[:l])
+	hex := strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X")
+	if hex && !strings.ContainsAny(s, ".pP") || !hex && !strings.ContainsAny(s, ".eE") {
+		return nil
+	}
+	ty := doubleType()
+	if strings.HasSuffix(s, "f") || strings.HasSuffix(s, "F") {
+		ty = floatType()
+		s = s[:len(s)-1]
+	} else if strings.HasSuffix(s, "l") || strings.HasSuffix(s, "L") {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		errorAt(p, "invalid floating constant")
+	}
+	cur = newToken(tkNum, cur, p, l)
+	cur.fval = v
+	cur.ty = ty
+	return cur
+}
+
+// literalPrefix returns the length of the encoding prefix of a string or
+// character literal starting at p, or -1 if p does not start one.
+func literalPrefix(p []rune) int {
+	for _, pre := range [...]string{"u8", "u", "U", "L", ""} {
+		if startWith(p, []rune(pre)) && len(p) > len(pre) && (p[len(pre)] == '"' || p[len(pre)] == '\'') {
+			return len(pre)
+		}
+	}
+	return -1
+}
+
+// literalType returns the element type of a string literal with the
+// given encoding prefix.
+func literalType(pre string) *typ {
+	switch pre {
+	case "L":
+		return int32Type()
+	case "u":
+		ty := shortType()
+		ty.isUnsigned = true
+		return ty
+	case "U":
+		ty := int32Type()
+		ty.isUnsigned = true
+		return ty
+	}
+	return charType()
+}
+
+// readEscape reads the escape sequence after a backslash at p. It
+// returns the value, the number of runes read and whether the value is
+// a numeric code unit rather than a character.
+func readEscape(p []rune) (rune, int, bool) {
+	if p[0] == 'x' {
+		n := 1
+		c := rune(0)
+		for n < len(p) && isHexDigit(p[n]) {
+			d, _ := strconv.ParseInt(string(p[n]), 16, 32)
+			c = c<<4 | rune(d)
+			n++
+		}
+		if n == 1 {
+			errorAt(p, "invalid hex escape sequence")
+		}
+		return c, n, true
+	}
+	if '0' <= p[0] && p[0] <= '7' {
+		n := 0
+		c := rune(0)
+		for n < 3 && n < len(p) && '0' <= p[n] && p[n] <= '7' {
+			c = c<<3 | (p[n] - '0')
+			n++
+		}
+		return c, n, true
+	}
+	if p[0] == 'u' || p[0] == 'U' {
+		c, n := readUCN(p)
+		return c, n, false
+	}
+	return getEscapeChar(p[0]), 1, false
+}
+
+func isHexDigit(c rune) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// encodeChar appends c to r encoded in code units of sz bytes: UTF-8 for
+// 1, UTF-16 for 2 and UTF-32 for 4.
+func encodeChar(r []rune, c rune, sz int) []rune {
+	switch sz {
+	case 1:
+		for _, b := range []byte(string(c)) {
+			r = append(r, rune(b))
+		}
+		return r
+	case 2:
+		for _, u := range utf16.Encode([]rune{c}) {
+			r = append(r, rune(u))
+		}
+		return r
+	}
+	return append(r, c)
+}
+
+// readLitContents reads the characters of a string or character literal
+// up to the closing quote q, starting just after the opening one. It
+// returns the code units and the number of runes read, including the
+// closing quote.
+func readLitContents(p []rune, q rune, sz int) ([]rune, int) {
+	s := p
 	r := make([]rune, 0)
 	for {
-		c := p[0]
-		if l == 1024 {
-			errorAt(p, "string literal too large")
-		}
-		if c == 0 {
-			errorAt(p, "unclosed string literal")
+		if len(p) == 0 || p[0] == '\n' || p[0] == 0 {
+			if q == '"' {
+				errorAt(s, "unclosed string literal")
+			}
+			errorAt(s, "unclosed char literal")
 		}
-		if c == '"' {
-			break
+		if p[0] == q {
+			return r, len(s) - len(p) + 1
 		}
-		if c == '\\' {
+		if p[0] != '\\' {
+			r = encodeChar(r, p[0], sz)
 			p = p[1:]
-			r = append(r, getEscapeChar(p[0]))
-			p = p[1:]
-		} else {
+			continue
+		}
+		c, n, isNum := readEscape(p[1:])
+		p = p[1+n:]
+		if isNum && sz < 4 {
+			r = append(r, c&(1<<uint(8*sz)-1))
+		} else if isNum {
 			r = append(r, c)
-			p = p[1:]
+		} else {
+			r = encodeChar(r, c, sz)
 		}
 	}
-	tok := newToken(tkStr, cur, s, len(s)-len(p)+1)
+}
+
+// readStrLit reads a string literal with an encoding prefix of pre runes.
+// Narrow and u8 strings hold UTF-8 bytes; u, U and L strings hold UTF-16
+// or UTF-32 code units. tok.ty is the element type.
+func readStrLit(cur *token, p []rune, pre int) *token {
+	ty := literalType(string(p[:pre]))
+	r, l := readLitContents(p[pre+1:], '"', sizeOf(ty))
+	tok := newToken(tkStr, cur, p, pre+1+l)
 	tok.contents = append(r, 0)
 	tok.contLen = len(tok.contents)
+	tok.ty = ty
+	return tok
+}
+
+// readCharLit reads a character literal with an encoding prefix of pre
+// runes. A plain one has type int and its value is that of the char
+// made of each byte; a multi-character constant packs its bytes like
+// gcc does.
+func readCharLit(cur *token, p []rune, pre int) *token {
+	prefix := string(p[:pre])
+	ty := literalType(prefix)
+	r, l := readLitContents(p[pre+1:], '\'', sizeOf(ty))
+	if len(r) == 0 {
+		errorAt(p, "empty character constant")
+	}
+	tok := newToken(tkNum, cur, p, pre+1+l)
+	switch {
+	case prefix == "":
+		tok.ty = intType()
+		if len(r) == 1 {
+			tok.val = int(int8(r[0]))
+			break
+		}
+		for _, c := range r {
+			tok.val = tok.val<<8 | int(c)
+		}
+	case len(r) > 1:
+		errorAt(p, "character too large for enclosed character literal type")
+	default:
+		tok.ty = ty
+		tok.val = int(uint32(r[0]))
+		if !ty.isUnsigned && sizeOf(ty) == 4 {
+			tok.val = int(int32(r[0]))
+		}
+	}
 	return tok
 }
 
@@ -357,6 +743,31 @@ func tokenize(p []rune) *token {
 			p = p[2:]
 			continue
 		}
+		if c == '#' {
+			l := stdargInclude(p)
+			if l == 0 {
+				errorAt(p, "unsupported preprocessing directive")
+			}
+			stdarg = true
+			p = p[l:]
+			continue
+		}
+		if isDigit(c) || c == '.' && len(p) > 1 && isDigit(p[1]) {
+			if tok := readFloatLit(cur, p); tok != nil {
+				cur = tok
+				p = p[cur.len:]
+				continue
+			}
+		}
+		if pre := literalPrefix(p); pre >= 0 {
+			if p[pre] == '"' {
+				cur = readStrLit(cur, p, pre)
+			} else {
+				cur = readCharLit(cur, p, pre)
+			}
+			p = p[cur.len:]
+			continue
+		}
 		kw := startWithReserved(p)
 		if kw != nil {
 			l := len(kw)
@@ -369,19 +780,11 @@ func tokenize(p []rune) *token {
 			p = p[1:]
 			continue
 		}
-		if isAlpha(c) {
-			q := p
-			r := len(p)
-			p = p[1:]
-			for len(p) > 0 && isAlNum(p[0]) {
-				p = p[1:]
-			}
-			cur = newToken(tkIdent, cur, q, r-len(p))
-			continue
-		}
-		if c == '"' {
-			cur = readStrLit(cur, p)
-			p = p[cur.len:]
+		if isIdent1(c) || isUCN(p) {
+			name, l := readIdent(p)
+			cur = newToken(tkIdent, cur, p, l)
+			cur.name = name
+			p = p[l:]
 			continue
 		}
 		if isDigit(c) {
@@ -44,3 +44,103 @@ func TestStrLit(t *testing.T) {
 	assert.Equal(t, []rune{0}, tt.contents)
 	assert.Equal(t, 1, tt.contLen)
 }
+
+func TestFloatLit(t *testing.T) {
+	tt := tokenize([]rune("1.5 .25f 1e3 0x1.8p1 7"))
+	assert.Equal(t, tkNum, tt.kind)
+	assert.Equal(t, 1.5, tt.fval)
+	assert.Equal(t, tyDouble, tt.ty.kind)
+	tt = tt.next
+	assert.Equal(t, 0.25, tt.fval)
+	assert.Equal(t, tyFloat, tt.ty.kind)
+	assert.Equal(t, 4, tt.len)
+	tt = tt.next
+	assert.Equal(t, 1000.0, tt.fval)
+	tt = tt.next
+	assert.Equal(t, 3.0, tt.fval)
+	tt = tt.next
+	assert.Nil(t, tt.ty)
+	assert.Equal(t, 7, tt.val)
+}
+
+func TestWideStrLit(t *testing.T) {
+	tt := tokenize([]rune(`"é" u8"é" L"é" u"𝄞" U"𝄞" "\xff\101"`))
+	assert.Equal(t, []rune{0xc3, 0xa9, 0}, tt.contents)
+	assert.Equal(t, tyChar, tt.ty.kind)
+	tt = tt.next
+	assert.Equal(t, []rune{0xc3, 0xa9, 0}, tt.contents)
+	tt = tt.next
+	assert.Equal(t, []rune{0xe9, 0}, tt.contents)
+	assert.Equal(t, tyInt32, tt.ty.kind)
+	assert.False(t, tt.ty.isUnsigned)
+	tt = tt.next
+	assert.Equal(t, []rune{0xd834, 0xdd1e, 0}, tt.contents)
+	assert.Equal(t, tyShort, tt.ty.kind)
+	tt = tt.next
+	assert.Equal(t, []rune{0x1d11e, 0}, tt.contents)
+	assert.True(t, tt.ty.isUnsigned)
+	tt = tt.next
+	assert.Equal(t, []rune{0xff, 'A', 0}, tt.contents)
+}
+
+func TestCharLit(t *testing.T) {
+	tt := tokenize([]rune(`'a' '\xff' 'ab' L'é' u'€' U'\xffffffff' u8'a'`))
+	assert.Equal(t, tkNum, tt.kind)
+	assert.Equal(t, 97, tt.val)
+	assert.Equal(t, tyInt, tt.ty.kind)
+	tt = tt.next
+	assert.Equal(t, -1, tt.val)
+	tt = tt.next
+	assert.Equal(t, 0x6162, tt.val)
+	tt = tt.next
+	assert.Equal(t, 0xe9, tt.val)
+	assert.Equal(t, tyInt32, tt.ty.kind)
+	tt = tt.next
+	assert.Equal(t, 0x20ac, tt.val)
+	assert.Equal(t, tyShort, tt.ty.kind)
+	tt = tt.next
+	assert.Equal(t, 0xffffffff, tt.val)
+	tt = tt.next
+	assert.Equal(t, 97, tt.val)
+	assert.Equal(t, tyChar, tt.ty.kind)
+}
+
+func TestUnicodeIdent(t *testing.T) {
+	tt := tokenize([]rune(`café caf\u00e9 \U000003c0x intä int`))
+	assert.Equal(t, tkIdent, tt.kind)
+	assert.Equal(t, []rune("café"), tt.name)
+	tt = tt.next
+	assert.Equal(t, []rune("café"), tt.name)
+	assert.Equal(t, 9, tt.len)
+	tt = tt.next
+	assert.Equal(t, []rune("πx"), tt.name)
+	tt = tt.next
+	assert.Equal(t, tkIdent, tt.kind)
+	assert.Equal(t, []rune("intä"), tt.name)
+	tt = tt.next
+	assert.Equal(t, tkReserved, tt.kind)
+}
+
+func TestNormalize(t *testing.T) {
+	r := normalize("a\r\nb\\\nc\\\r\nd\re")
+	assert.Equal(t, []rune("a\nbcd\ne\n"), r)
+	assert.Equal(t, []int{0, 1, 3, 6, 10, 11, 12, 13, 13}, srcPos)
+	r = normalize("")
+	assert.Equal(t, []rune("\n"), r)
+	assert.Equal(t, []int{0, 0}, srcPos)
+	srcPos = nil
+}
+
+func TestLocate(t *testing.T) {
+	k := []rune("int a;\rint b;\rc;\r")
+	line, start, end := locate(k, 14)
+	assert.Equal(t, 3, line)
+	assert.Equal(t, "c;", string(k[start:end]))
+	k = []rune("a\r\nb\nc\rd")
+	line, start, end = locate(k, 8)
+	assert.Equal(t, 4, line)
+	assert.Equal(t, "d", string(k[start:end]))
+	line, start, end = locate(k, 3)
+	assert.Equal(t, 2, line)
+	assert.Equal(t, "b", string(k[start:end]))
+}
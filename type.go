@@ -1,13 +1,31 @@
 package main
 
+import "reflect"
+
 func charType() *typ {
 	return &typ{kind: tyChar}
 }
 
+func shortType() *typ {
+	return &typ{kind: tyShort}
+}
+
+func int32Type() *typ {
+	return &typ{kind: tyInt32}
+}
+
 func intType() *typ {
 	return &typ{kind: tyInt}
 }
 
+func floatType() *typ {
+	return &typ{kind: tyFloat}
+}
+
+func doubleType() *typ {
+	return &typ{kind: tyDouble}
+}
+
 func pointerTo(b *typ) *typ {
 	return &typ{kind: tyPtr, base: b}
 }
@@ -16,18 +34,264 @@ func arrayOf(b *typ, s int) *typ {
 	return &typ{kind: tyArray, base: b, arraySize: s}
 }
 
+func funcType(ret *typ) *typ {
+	return &typ{kind: tyFunc, returnTy: ret}
+}
+
+const (
+	qConst = 1 << iota
+	qVolatile
+	qRestrict
+)
+
+// qualify returns a copy of ty with the qualifiers in q added. The copy
+// remembers the unqualified type it was made from.
+func qualify(ty *typ, q int) *typ {
+	if q == 0 {
+		return ty
+	}
+	c := *ty
+	c.origin = unqual(ty)
+	c.isConst = c.isConst || q&qConst != 0
+	c.isVolatile = c.isVolatile || q&qVolatile != 0
+	c.isRestrict = c.isRestrict || q&qRestrict != 0
+	return &c
+}
+
+func qualifiers(ty *typ) int {
+	q := 0
+	if ty.isConst {
+		q |= qConst
+	}
+	if ty.isVolatile {
+		q |= qVolatile
+	}
+	if ty.isRestrict {
+		q |= qRestrict
+	}
+	return q
+}
+
+func unqual(ty *typ) *typ {
+	if ty.origin != nil {
+		return ty.origin
+	}
+	return ty
+}
+
+// hasConstMember reports whether a struct has a const member at any depth,
+// which makes the whole struct unassignable.
+func hasConstMember(ty *typ) bool {
+	if ty.kind != tyStruct {
+		return false
+	}
+	for m := ty.members; m != nil; m = m.next {
+		if m.ty.isConst || hasConstMember(m.ty) {
+			return true
+		}
+	}
+	return false
+}
+
+func vaElemType() *typ {
+	return &typ{kind: tyStruct, size: 24, align: 8}
+}
+
+func vaListType() *typ {
+	return arrayOf(vaElemType(), 1)
+}
+
 func sizeOf(ty *typ) int {
 	switch ty.kind {
 	case tyChar:
 		return 1
+	case tyShort:
+		return 2
+	case tyInt32:
+		fallthrough
+	case tyFloat:
+		return 4
 	case tyInt:
 		fallthrough
+	case tyVla:
+		// A variable-length array object holds a pointer to its storage.
+		fallthrough
+	case tyDouble:
+		fallthrough
 	case tyPtr:
 		return 8
+	case tyStruct:
+		return ty.size
+	case tyFunc:
+		return 1
 	}
 	return sizeOf(ty.base) * ty.arraySize
 }
 
+func alignOf(ty *typ) int {
+	switch ty.kind {
+	case tyArray:
+		return alignOf(ty.base)
+	case tyStruct:
+		return ty.align
+	}
+	return sizeOf(ty)
+}
+
+// varAlign returns the alignment of v, which is at least that of its
+// type.
+func varAlign(v *va) int {
+	return maxAlign(v.align, alignOf(v.ty))
+}
+
+// memberAlign returns the alignment of m. A packed member is aligned
+// only as far as an explicit alignment asks.
+func memberAlign(m *member) int {
+	if m.isPacked {
+		return maxAlign(m.align, 1)
+	}
+	return maxAlign(m.align, alignOf(m.ty))
+}
+
+func isFlonum(ty *typ) bool {
+	return ty.kind == tyFloat || ty.kind == tyDouble
+}
+
+func isScalar(ty *typ) bool {
+	return ty.kind != tyArray && ty.kind != tyStruct && ty.kind != tyFunc && ty.kind != tyVla
+}
+
+// isUnsignedOp reports whether integer operation n is performed in
+// unsigned arithmetic.
+func isUnsignedOp(n *node) bool {
+	return n.lhs.ty.kind == tyInt && n.lhs.ty.isUnsigned || n.rhs.ty.kind == tyInt && n.rhs.ty.isUnsigned
+}
+
+func isBitfield(n *node) bool {
+	return n.kind == ndMember && n.member.isBitfield
+}
+
+func newCast(n *node, ty *typ) *node {
+	if n.ty.kind == ty.kind {
+		return n
+	}
+	return &node{kind: ndCast, lhs: n, ty: ty, tok: n.tok}
+}
+
+// usualArithConv converts both operands of n to their common real type
+// when either of them is floating.
+func usualArithConv(n *node) bool {
+	if !isFlonum(n.lhs.ty) && !isFlonum(n.rhs.ty) {
+		return false
+	}
+	ty := floatType()
+	if n.lhs.ty.kind == tyDouble || n.rhs.ty.kind == tyDouble {
+		ty = doubleType()
+	}
+	n.lhs = newCast(n.lhs, ty)
+	n.rhs = newCast(n.rhs, ty)
+	return true
+}
+
+// convertArgs casts arguments to the declared parameter types and applies
+// the default argument promotions to the rest.
+func convertArgs(n *node, fty *typ) {
+	var pl *paramlist
+	if fty != nil {
+		pl = fty.params
+	}
+	var h node
+	cur := &h
+	for a := n.args; a != nil; {
+		next := a.next
+		a.next = nil
+		if pl != nil {
+			if !n.isChecked {
+				checkDiscardedQualifiers(pl.ty, a.ty, a.tok)
+			}
+			if isScalar(pl.ty) && (isFlonum(a.ty) || isFlonum(pl.ty)) {
+				a = newCast(a, pl.ty)
+			}
+			pl = pl.next
+		} else if a.ty.kind == tyFloat {
+			a = newCast(a, doubleType())
+		}
+		cur.next = a
+		cur = a
+		a = next
+	}
+	n.args = h.next
+	n.isChecked = true
+}
+
+func isCompatible(t1 *typ, t2 *typ) bool {
+	if t1.isConst != t2.isConst || t1.isVolatile != t2.isVolatile {
+		return false
+	}
+	t1 = unqual(t1)
+	t2 = unqual(t2)
+	if t1 == t2 {
+		return true
+	}
+	if t1.kind != t2.kind {
+		return false
+	}
+	switch t1.kind {
+	case tyChar:
+		fallthrough
+	case tyShort:
+		fallthrough
+	case tyInt32:
+		fallthrough
+	case tyInt:
+		return t1.isUnsigned == t2.isUnsigned
+	case tyFloat:
+		fallthrough
+	case tyDouble:
+		return true
+	case tyPtr:
+		return isCompatible(t1.base, t2.base)
+	case tyArray:
+		if !isCompatible(t1.base, t2.base) {
+			return false
+		}
+		return t1.isIncomplete || t2.isIncomplete || t1.arraySize == t2.arraySize
+	case tyFunc:
+		if !isCompatible(t1.returnTy, t2.returnTy) || t1.isVariadic != t2.isVariadic {
+			return false
+		}
+		p1, p2 := t1.params, t2.params
+		for ; p1 != nil && p2 != nil; p1, p2 = p1.next, p2.next {
+			if !isCompatible(p1.ty, p2.ty) {
+				return false
+			}
+		}
+		return p1 == nil && p2 == nil
+	}
+	return false
+}
+
+func checkDiscardedQualifiers(to *typ, from *typ, tok *token) {
+	if to.kind != tyPtr || from.kind != tyPtr {
+		return
+	}
+	if from.base.isConst && !to.base.isConst {
+		warnTok(tok, "assignment discards 'const' qualifier from pointer target type")
+	}
+	if from.base.isVolatile && !to.base.isVolatile {
+		warnTok(tok, "assignment discards 'volatile' qualifier from pointer target type")
+	}
+}
+
+func findMember(ty *typ, name []rune) *member {
+	for m := ty.members; m != nil; m = m.next {
+		if reflect.DeepEqual(m.name, name) {
+			return m
+		}
+	}
+	return nil
+}
+
 func visit(n *node) {
 	if n == nil {
 		return
@@ -49,7 +313,13 @@ func visit(n *node) {
 	case ndMul:
 		fallthrough
 	case ndDiv:
-		fallthrough
+		if usualArithConv(n) {
+			n.ty = n.lhs.ty
+		} else {
+			n.ty = intType()
+			n.ty.isUnsigned = isUnsignedOp(n)
+		}
+		return
 	case ndEq:
 		fallthrough
 	case ndNe:
@@ -57,12 +327,33 @@ func visit(n *node) {
 	case ndLt:
 		fallthrough
 	case ndLe:
-		fallthrough
-	case ndFunCall:
-		fallthrough
-	case ndNum:
+		usualArithConv(n)
 		n.ty = intType()
 		return
+	case ndNum:
+		if n.ty == nil {
+			n.ty = intType()
+		}
+		return
+	case ndFunCall:
+		if n.lhs != nil {
+			fty := n.lhs.ty
+			if fty.kind == tyPtr {
+				fty = fty.base
+			}
+			if fty.kind != tyFunc {
+				errorTok(n.tok, "called object is not a function")
+			}
+			n.ty = fty.returnTy
+			convertArgs(n, fty)
+		} else if n.v != nil {
+			n.ty = n.v.ty.returnTy
+			convertArgs(n, n.v.ty)
+		} else {
+			n.ty = intType()
+			convertArgs(n, nil)
+		}
+		return
 	case ndVar:
 		n.ty = n.v.ty
 		return
@@ -75,19 +366,42 @@ func visit(n *node) {
 		if n.rhs.ty.base != nil {
 			errorTok(n.tok, "invalid pointer arithmetic operands")
 		}
+		if !usualArithConv(n) && n.lhs.ty.base == nil {
+			n.ty = intType()
+			n.ty.isUnsigned = isUnsignedOp(n)
+			return
+		}
 		n.ty = n.lhs.ty
 		return
 	case ndSub:
 		if n.rhs.ty.base != nil {
 			errorTok(n.tok, "invalid pointer arithmetic operands")
 		}
+		if !usualArithConv(n) && n.lhs.ty.base == nil {
+			n.ty = intType()
+			n.ty.isUnsigned = isUnsignedOp(n)
+			return
+		}
 		n.ty = n.lhs.ty
 		return
 	case ndAssign:
+		if !n.isInit && (n.lhs.ty.isConst || hasConstMember(n.lhs.ty)) {
+			errorTok(n.tok, "cannot assign to const-qualified lvalue")
+		}
+		if !n.isChecked {
+			checkDiscardedQualifiers(n.lhs.ty, n.rhs.ty, n.tok)
+			n.isChecked = true
+		}
+		if isScalar(n.lhs.ty) && (isFlonum(n.lhs.ty) || isFlonum(n.rhs.ty)) {
+			n.rhs = newCast(n.rhs, n.lhs.ty)
+		}
 		n.ty = n.lhs.ty
 		return
 	case ndAddr:
-		if n.lhs.ty.kind == tyArray {
+		if isBitfield(n.lhs) {
+			errorTok(n.tok, "cannot take address of bit-field")
+		}
+		if n.lhs.ty.kind == tyArray || n.lhs.ty.kind == tyVla {
 			n.ty = pointerTo(n.lhs.ty.base)
 		} else {
 			n.ty = pointerTo(n.lhs.ty)
@@ -100,11 +414,94 @@ func visit(n *node) {
 		n.ty = n.lhs.ty.base
 		return
 	case ndSizeOf:
+		if isBitfield(n.lhs) {
+			errorTok(n.tok, "sizeof applied to a bit-field")
+		}
+		if n.lhs.ty.isIncomplete {
+			errorTok(n.tok, "invalid application of sizeof to an incomplete type")
+		}
+		if n.lhs.ty.kind == tyVla {
+			n.kind = ndVar
+			n.v = n.lhs.ty.vlaSize
+			n.ty = intType()
+			n.lhs = nil
+			return
+		}
 		n.kind = ndNum
 		n.ty = intType()
 		n.val = sizeOf(n.lhs.ty)
 		n.lhs = nil
 		return
+	case ndAlloca:
+		n.ty = pointerTo(charType())
+		return
+	case ndVlaPtr:
+		n.ty = pointerTo(n.v.ty.base)
+		return
+	case ndCond:
+		if isFlonum(n.then.ty) || isFlonum(n.els.ty) {
+			ty := floatType()
+			if n.then.ty.kind == tyDouble || n.els.ty.kind == tyDouble {
+				ty = doubleType()
+			}
+			n.then = newCast(n.then, ty)
+			n.els = newCast(n.els, ty)
+		}
+		n.ty = n.then.ty
+		return
+	case ndMember:
+		if n.lhs.ty.kind != tyStruct {
+			errorTok(n.tok, "not a struct")
+		}
+		if n.member == nil {
+			n.member = findMember(n.lhs.ty, n.memberName)
+		}
+		if n.member == nil {
+			errorTok(n.tok, "specified member does not exist")
+		}
+		n.ty = qualify(n.member.ty, qualifiers(n.lhs.ty))
+		return
+	case ndGeneric:
+		ty := unqual(n.lhs.ty)
+		if ty.kind == tyArray || ty.kind == tyVla {
+			ty = pointerTo(ty.base)
+		} else if ty.kind == tyFunc {
+			ty = pointerTo(ty)
+		}
+		var sel *node
+		for a := n.assocs; a != nil; a = a.next {
+			if a.ty == nil {
+				if sel == nil {
+					sel = a.expr
+				}
+			} else if isCompatible(ty, a.ty) {
+				sel = a.expr
+				break
+			}
+		}
+		if sel == nil {
+			errorTok(n.tok, "controlling expression type not compatible with any generic association type")
+		}
+		next := n.next
+		*n = *sel
+		n.next = next
+		visit(n)
+		return
+	case ndAsm:
+		for op := n.asm.ops; op != nil; op = op.next {
+			visit(op.expr)
+			if op.isOutput && (op.expr.ty.isConst || hasConstMember(op.expr.ty)) {
+				errorTok(op.expr.tok, "cannot assign to const-qualified lvalue")
+			}
+		}
+		return
+	case ndStmtExpr:
+		last := n.body
+		for last.next != nil {
+			last = last.next
+		}
+		n.ty = last.ty
+		return
 	}
 
 }